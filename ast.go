@@ -37,9 +37,16 @@ func NewASTNode(node *bf.Node) *ASTNode {
 	return a
 }
 
-// Ast takes an input and returns a JSON-friendly ASTNode
+// Ast takes an input and returns a JSON-friendly ASTNode.
+// Any front matter is the first child of the document, as a
+// FrontMatter node holding it as written.
 func Ast(src []byte, opts ...bf.Option) *ASTNode {
+	fm, src := SplitFrontMatter(src)
 	md := bf.New(opts...)
 	node := md.Parse(src)
-	return NewASTNode(node)
+	a := NewASTNode(node)
+	if fm != nil {
+		a.Children = append([]*ASTNode{{Type: "FrontMatter", Literal: string(fm.Raw), Attr: fm}}, a.Children...)
+	}
+	return a
 }
@@ -0,0 +1,147 @@
+package mdtool
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+// Blackfriday parses <https://golang.org/>, a bare https://golang.org/
+// and [https://golang.org/](https://golang.org/) to the same link, so
+// how each URL was written is found by scanning the source first.
+
+// autoLinkSource matches, in order: code spans and link reference
+// definitions, which are skipped, <url> and <email> autolinks, inline
+// links and bare URLs
+var autoLinkSource = regexp.MustCompile("`[^`\\n]*`" +
+	`|(?m:^ {0,3}\[[^\]]+\]:[ \t]*\S+)` +
+	`|<([A-Za-z][A-Za-z0-9.+-]{1,31}:[^\s<>]*|[^\s<>@\\]+@[^\s<>@]+)>` +
+	`|\[([^\]]*)\]\(\s*<?([^\s)>]*)>?[^)]*\)` +
+	`|((?:https?|ftp|file)://[^\s<>]*)`)
+
+// autoLink is how a URL was written
+type autoLink int
+
+const (
+	autoLinkBare     autoLink = iota // https://golang.org/
+	autoLinkAngled                   // <https://golang.org/>
+	autoLinkExplicit                 // [https://golang.org/](https://golang.org/)
+	autoLinkEscaped                  // \<https://golang.org/>, bare after a literal <
+)
+
+type writtenLink struct {
+	form autoLink
+	text string // <url> as written
+}
+
+// autoLinks is how each link that looks like an autolink was written,
+// in order, keyed by the URL as blackfriday shows it
+type autoLinks struct {
+	written map[string][]writtenLink
+	angle   bool // write bare URLs as <url>
+}
+
+// scanAutoLinks finds the autolinks, bare URLs and [url](url) links in
+// the source, outside fenced code and code spans
+func scanAutoLinks(src []byte, opt *FmtOptions) *autoLinks {
+	links := &autoLinks{
+		written: make(map[string][]writtenLink),
+		angle:   opt != nil && opt.AutoLinks == "angle",
+	}
+	add := func(url []byte, link writtenLink) {
+		key := autoLinkKey(unescapeURL(url))
+		links.written[key] = append(links.written[key], link)
+	}
+	code := fencedCode(src)
+	for _, m := range autoLinkSource.FindAllSubmatchIndex(src, -1) {
+		if inRange(code, m[0]) != -1 {
+			continue
+		}
+		switch {
+		case m[2] != -1 && isEscaped(src, m[0]):
+			// blackfriday links the URL with the > after it
+			add(src[m[2]:m[1]], writtenLink{form: autoLinkEscaped})
+		case m[2] != -1:
+			add(src[m[2]:m[3]], writtenLink{autoLinkAngled, string(src[m[0]:m[1]])})
+		case m[4] != -1:
+			text, dest := src[m[4]:m[5]], src[m[6]:m[7]]
+			if autoLinkKey(text) == autoLinkKey(dest) {
+				add(text, writtenLink{form: autoLinkExplicit})
+			}
+		case m[8] != -1:
+			// blackfriday only links a bare URL after space or emphasis,
+			// and leaves out punctuation at the end
+			if m[0] == 0 || bytes.IndexByte([]byte(" \t\n*_~"), src[m[0]-1]) != -1 {
+				add(bytes.TrimRight(src[m[8]:m[9]], ".,:;!?\"')"), writtenLink{form: autoLinkBare})
+			}
+		}
+	}
+	return links
+}
+
+// isEscaped is true if the byte at i follows an odd number of
+// backslashes
+func isEscaped(src []byte, i int) bool {
+	n := 0
+	for i-n > 0 && src[i-n-1] == '\\' {
+		n++
+	}
+	return n%2 == 1
+}
+
+// unescapeURL removes backslash escapes, as blackfriday does in
+// autolinks
+func unescapeURL(url []byte) []byte {
+	out := make([]byte, 0, len(url))
+	for i := 0; i < len(url); i++ {
+		if url[i] == '\\' && i+1 < len(url) {
+			i++
+		}
+		out = append(out, url[i])
+	}
+	return out
+}
+
+// autoLinkKey is the URL without mailto:, as in an autolink's text
+func autoLinkKey(url []byte) string {
+	return strings.TrimPrefix(string(url), "mailto:")
+}
+
+// next returns how the next link to the URL was written.  A URL that
+// wasn't found in the source is taken to be bare.
+func (l *autoLinks) next(url []byte) writtenLink {
+	if l == nil {
+		return writtenLink{}
+	}
+	key := autoLinkKey(url)
+	written := l.written[key]
+	if len(written) == 0 {
+		return writtenLink{}
+	}
+	l.written[key] = written[1:]
+	return written[0]
+}
+
+// nextAutoLink is next for a parser that only calls for autolinks and
+// bare URLs, skipping any [url](url) links
+func (l *autoLinks) nextAutoLink(url []byte) writtenLink {
+	link := l.next(url)
+	for link.form == autoLinkExplicit {
+		link = l.next(url)
+	}
+	return link
+}
+
+// write writes a bare URL or <url> autolink
+func (l *autoLinks) write(out *bytes.Buffer, url []byte, link writtenLink) {
+	switch {
+	case link.form == autoLinkAngled:
+		out.WriteString(link.text)
+	case l != nil && l.angle && link.form != autoLinkEscaped:
+		out.WriteByte('<')
+		out.Write(escape(url))
+		out.WriteByte('>')
+	default:
+		out.Write(escape(url))
+	}
+}
@@ -0,0 +1,38 @@
+package mdtool
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFmtAutoLinks(t *testing.T) {
+	cases := []struct {
+		src   string
+		style string
+		want  string
+	}{
+		{"<https://golang.org/>\n", "", "<https://golang.org/>\n"},
+		{"<user@example.com>\n", "", "<user@example.com>\n"},
+		{"<mailto:user@example.com>\n", "", "<mailto:user@example.com>\n"},
+		{"a https://golang.org/ b\n", "", "a https://golang.org/ b\n"},
+		{"a https://golang.org/ b\n", "angle", "a <https://golang.org/> b\n"},
+		{"<https://golang.org/>\n", "angle", "<https://golang.org/>\n"},
+		{"https://golang.org/ <https://golang.org/>\n", "", "https://golang.org/ <https://golang.org/>\n"},
+		{"<https://golang.org/> https://golang.org/\n", "", "<https://golang.org/> https://golang.org/\n"},
+		{"`<https://golang.org/>` https://golang.org/\n", "", "`<https://golang.org/>` https://golang.org/\n"},
+		{"[https://golang.org/](https://golang.org/) https://golang.org/\n", "", "[https://golang.org/](https://golang.org/) https://golang.org/\n"},
+		{"\\<http://x.com>\n", "", "\\<http://x.com>\n"},
+		{"\\<http://x.com>\n", "angle", "\\<http://x.com>\n"},
+		{"\\\\<http://x.com>\n", "angle", "\\\\<http://x.com>\n"},
+	}
+	for idx, tt := range cases {
+		opt := &FmtOptions{LineLength: 70, AutoLinks: tt.style}
+		for _, format := range []func([]byte, *FmtOptions) []byte{Fmt, Fmt2} {
+			// Fmt starts with a blank line
+			got := strings.TrimLeft(string(format([]byte(tt.src), opt)), "\n")
+			if got != tt.want {
+				t.Errorf("Case %d)\n%q\n%q", idx, tt.want, got)
+			}
+		}
+	}
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/client9/markdown_tools"
@@ -18,26 +22,46 @@ var (
 )
 
 var (
-	versionCommand     = kingpin.Command("version", "show version and exit")
-	astCommand         = kingpin.Command("ast", "dump JSON representation of AST")
-	vetCommand         = kingpin.Command("vet", "vet markdown structure")
-	vetFiles           = vetCommand.Arg("files", "file to process, if none use stdin").Strings()
-	fmtCommand         = kingpin.Command("fmt", "reformat markdown")
-	fmt2Command        = kingpin.Command("fmt2", "reformat markdown, take 2")
-	fmtWrite           = fmtCommand.Flag("write", "write in place").Short('w').Bool()
-	fmtFiles           = fmtCommand.Arg("files", "file to process, if none use stdin").Strings()
-	fmtLineLength      = fmtCommand.Flag("linelength", "line length, -1=unlimited").Default("70").Int()
-	fmtHrLength        = fmtCommand.Flag("hrlength", "HR length").Default("3").Int()
-	fmtHrChar          = fmtCommand.Flag("hrchar", "HR char").Default("-").String()
-	fmtListIndent      = fmtCommand.Flag("listindent", "list indent").Default("  ").String()
-	fmtListBulletChar  = fmtCommand.Flag("listbullet", "list bullet").Default("-").String()
-	fmtListBulletSpace = fmtCommand.Flag("listbulletspace", "list bullet space").Default(" ").String()
+	versionCommand = kingpin.Command("version", "show version and exit")
+	astCommand     = kingpin.Command("ast", "dump JSON representation of AST")
+	vetCommand     = kingpin.Command("vet", "vet markdown structure")
+	vetFiles       = vetCommand.Arg("files", "file to process, if none use stdin").Strings()
+	vetMaxWarnings = vetCommand.Flag("max-warnings", "fail if more than N warnings, -1=unlimited").Default("-1").Int()
+	vetFailOn      = vetCommand.Flag("fail-on", "lowest severity that fails").Default("error").Enum("error", "warning")
+	vetSeverity    = vetCommand.Flag("severity", "override rule severity, e.g. runaway-code-fence=warning").StringMap()
+	vetDisable     = vetCommand.Flag("disable", "disable rule, e.g. naked-url-parens").Strings()
+	vetEntry       = vetCommand.Flag("entry", "entry point pages when vetting a directory").Default("README.md").Strings()
+	vetCheckCode   = vetCommand.Flag("check-code", "report code blocks that fail to format").Bool()
+	vetCodeFmt     = vetCommand.Flag("codefmt", "external code formatter, e.g. sh='shfmt -i 2'").StringMap()
+	fmtCommand     = kingpin.Command("fmt", "reformat markdown")
+	fmtFlags       = newFmtFlags(fmtCommand)
+	fmt2Command    = kingpin.Command("fmt2", "reformat markdown, take 2")
+	fmt2Flags      = newFmtFlags(fmt2Command)
+
+	configCommand = kingpin.Command("config", "print the effective config for a file or directory")
+	configPath    = configCommand.Arg("path", "file or directory").Default(".").String()
 
 	renderCommand = kingpin.Command("render", "render markdown to another format")
 	renderType    = renderCommand.Arg("type", "render type").Default("html").String()
+
+	specCommand = kingpin.Command("spec", "report which CommonMark or GFM spec examples fmt and fmt2 change the HTML of")
+	specFile    = specCommand.Arg("file", "spec JSON, from the spec's test/spec_tests.py --dump-tests").Required().String()
+	specLossy   = specCommand.Flag("lossy", "list the changed examples of each section").Bool()
 )
 
+// flagsSet are the flags given on the command line, which override
+// settings from config files
+var flagsSet = make(map[string]bool)
+
 func main() {
+	kingpin.CommandLine.PreAction(func(ctx *kingpin.ParseContext) error {
+		for _, el := range ctx.Elements {
+			if flag, ok := el.Clause.(*kingpin.FlagClause); ok {
+				flagsSet[flag.Model().Name] = true
+			}
+		}
+		return nil
+	})
 	switch kingpin.Parse() {
 	case "version":
 		fmt.Println(version)
@@ -55,76 +79,59 @@ func main() {
 		fmt.Println(string(rawout))
 		return
 	case "fmt2":
-		rawin, err := ioutil.ReadAll(os.Stdin)
-		if err != nil {
-			log.Fatal(err)
-		}
-		out := mdtool.Fmt2(rawin)
-		fmt.Println(string(out))
-		return
+		fmt2Flags.run(mdtool.Fmt2, mdtool.Fmt2Range)
 	case "fmt":
-		bulletSpace := strings.Replace(*fmtListBulletSpace, "\\t", "\t", -1)
-		bulletIndent := strings.Replace(*fmtListIndent, "\\t", "\t", -1)
-		opt := mdtool.FmtOptions{
-			LineLength:      *fmtLineLength,
-			HrChar:          *fmtHrChar,
-			HrLength:        *fmtHrLength,
-			ListBulletChar:  *fmtListBulletChar,
-			ListIndent:      bulletIndent,
-			ListBulletSpace: bulletSpace,
-		}
-
-		if len(*fmtFiles) == 0 {
-			rawin, err := ioutil.ReadAll(os.Stdin)
-			if err != nil {
-				log.Fatal(err)
-			}
-			out := mdtool.Fmt(rawin, &opt)
-			fmt.Println(string(out))
-			return
+		fmtFlags.run(mdtool.Fmt, mdtool.FmtRange)
+	case "config":
+		cfg := loadConfig(*configPath)
+		for _, name := range cfg.Files {
+			fmt.Printf("# %s\n", name)
 		}
-		for _, name := range *fmtFiles {
-			rawin, err := ioutil.ReadFile(name)
-			if err != nil {
-				log.Fatalf("Can't read %q: %s", name, err)
-			}
-			out := mdtool.Fmt(rawin, &opt)
-
-			if !*fmtWrite {
-				fmt.Println(string(out))
-				continue
-			}
-			ioutil.WriteFile(name, out, 0)
+		if !cfg.Included(*configPath) {
+			fmt.Printf("# %s is excluded\n", *configPath)
 		}
+		os.Stdout.Write(cfg.Bytes())
 	case "vet":
-		errCount := 0
+		color := isTerminal(os.Stdout)
+		counts := make(map[mdtool.Severity]int)
 		if len(*vetFiles) == 0 {
 			rawin, err := ioutil.ReadAll(os.Stdin)
 			if err != nil {
 				log.Fatal(err)
 			}
-			faults := mdtool.Vet(rawin)
-
+			faults := mdtool.Vet(rawin, vetOptions(loadConfig(".")))
 			for _, f := range faults {
-				errCount++
-				fmt.Printf("%d:%d offset=%d reason=%s %q\n", f.Row, f.Column, f.Offset, f.Reason, f.Line)
-			}
-			if len(faults) > 0 {
-				os.Exit(2)
+				counts[f.Severity]++
+				fmt.Printf("%d:%d %s offset=%d reason=%s %q\n", f.Row, f.Column, severityLabel(f.Severity, color), f.Offset, f.Reason, f.Line)
 			}
 		}
-		for _, name := range *vetFiles {
+		files, hasDir, err := expandFiles(*vetFiles)
+		if err != nil {
+			log.Fatal(err)
+		}
+		docs := make([]mdtool.Doc, 0, len(files))
+		for _, name := range files {
 			rawin, err := ioutil.ReadFile(name)
 			if err != nil {
 				log.Fatalf("Can't read %q: %s", name, err)
 			}
-			faults := mdtool.Vet(rawin)
+			docs = append(docs, mdtool.Doc{Name: filepath.ToSlash(name), Raw: rawin})
+		}
+		// checks across all the documents only make sense
+		// if given a directory
+		docFaults := map[string][]mdtool.Fault{}
+		if hasDir {
+			docFaults = mdtool.VetDocs(docs, vetOptions(loadConfig((*vetFiles)[0])))
+		}
+		for i, name := range files {
+			faults := append(mdtool.Vet(docs[i].Raw, vetOptions(loadConfig(name))), docFaults[docs[i].Name]...)
+			sort.SliceStable(faults, func(i, j int) bool { return faults[i].Offset < faults[j].Offset })
 			for _, f := range faults {
-				errCount++
-				fmt.Printf("%s:%d:%d offset=%d reason=%s %q\n", name, f.Row, f.Column, f.Offset, f.Reason, f.Line)
+				counts[f.Severity]++
+				fmt.Printf("%s:%d:%d %s offset=%d reason=%s %q\n", name, f.Row, f.Column, severityLabel(f.Severity, color), f.Offset, f.Reason, f.Line)
 			}
 		}
-		if errCount > 0 {
+		if vetFailed(counts, *vetFailOn, *vetMaxWarnings) {
 			os.Exit(2)
 		}
 	case "render":
@@ -144,5 +151,530 @@ func main() {
 			log.Fatalf("Unknown render type %q", *renderType)
 		}
 		os.Stdout.Write(rawout)
+	case "spec":
+		examples, err := mdtool.LoadSpec(*specFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		specReport(mdtool.CheckSpec(examples, nil), *specLossy)
+	}
+}
+
+// specReport prints how many examples of each spec section fmt and fmt2
+// keep, and with lossy the numbers of those they don't
+func specReport(sections []mdtool.SpecSection, lossy bool) {
+	width := len("total")
+	for _, s := range sections {
+		if len(s.Name) > width {
+			width = len(s.Name)
+		}
+	}
+	kept := func(n int, changed []int) string {
+		return fmt.Sprintf("%d/%d", n-len(changed), n)
+	}
+	total := mdtool.SpecSection{Name: "total"}
+	fmt.Printf("%-*s %9s %9s\n", width, "section", "fmt", "fmt2")
+	for _, s := range sections {
+		fmt.Printf("%-*s %9s %9s\n", width, s.Name, kept(s.Examples, s.FmtLossy), kept(s.Examples, s.Fmt2Lossy))
+		if lossy && len(s.FmtLossy) != 0 {
+			fmt.Printf("    fmt: %v\n", s.FmtLossy)
+		}
+		if lossy && len(s.Fmt2Lossy) != 0 {
+			fmt.Printf("    fmt2: %v\n", s.Fmt2Lossy)
+		}
+		total.Examples += s.Examples
+		total.FmtLossy = append(total.FmtLossy, s.FmtLossy...)
+		total.Fmt2Lossy = append(total.Fmt2Lossy, s.Fmt2Lossy...)
+	}
+	fmt.Printf("%-*s %9s %9s\n", width, total.Name, kept(total.Examples, total.FmtLossy), kept(total.Examples, total.Fmt2Lossy))
+}
+
+// loadConfig loads the config for a file, exiting on error
+func loadConfig(name string) *mdtool.Config {
+	cfg, err := mdtool.LoadConfig(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return cfg
+}
+
+// vetOptions returns the vet options from the config, with any
+// command line flags applied
+func vetOptions(cfg *mdtool.Config) *mdtool.VetOptions {
+	opt, err := cfg.VetOptions()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if flagsSet["entry"] {
+		opt.EntryPoints = *vetEntry
+	}
+	if *vetCheckCode && opt.CodeFormatters == nil {
+		opt.CodeFormatters = mdtool.DefaultCodeFormatters()
+		for lang, f := range cfg.FmtOptions().CodeFormatters {
+			opt.CodeFormatters.Register(lang, f)
+		}
+	}
+	if opt.CodeFormatters != nil {
+		for lang, command := range *vetCodeFmt {
+			opt.CodeFormatters.Register(lang, mdtool.ExternalCodeFormatter(command))
+		}
+	}
+	for _, name := range *vetDisable {
+		ft := mdtool.ParseFaultType(name)
+		if ft == mdtool.FaultZero {
+			log.Fatalf("Unknown vet rule %q", name)
+		}
+		opt.Disable[ft] = true
+	}
+	for name, level := range *vetSeverity {
+		ft := mdtool.ParseFaultType(name)
+		if ft == mdtool.FaultZero {
+			log.Fatalf("Unknown vet rule %q", name)
+		}
+		sev, err := mdtool.ParseSeverity(level)
+		if err != nil {
+			log.Fatalf("Bad severity for %q: %s", name, err)
+		}
+		opt.Severity[ft] = sev
+	}
+	return opt
+}
+
+// formatFlags are the command line options shared by fmt and fmt2
+type formatFlags struct {
+	write           *bool
+	list            *bool
+	diff            *bool
+	check           *bool
+	verify          *bool
+	files           *[]string
+	lines           *string
+	style           *string
+	lineLength      *int
+	wrap            *string
+	tabWidth        *int
+	hrLength        *int
+	hrChar          *string
+	listIndent      *string
+	listBulletChar  *string
+	listBulletSpace *string
+	listNumbering   *string
+	headingStyle    *string
+	emphasis        *string
+	strong          *string
+	tableCompact    *bool
+	renumberNotes   *bool
+	hardBreak       *string
+	linkStyle       *string
+	linkRefs        *string
+	autoLinks       *string
+	frontMatter     *string
+	lineEnding      *string
+	codeFence       *string
+	indentedCode    *string
+	codeAlias       *map[string]string
+	codeFmt         *map[string]string
+}
+
+func newFmtFlags(cmd *kingpin.CmdClause) formatFlags {
+	return formatFlags{
+		write:           cmd.Flag("write", "write in place").Short('w').Bool(),
+		list:            cmd.Flag("list", "list files whose formatting differs").Short('l').Bool(),
+		diff:            cmd.Flag("diff", "display diffs instead of rewriting files").Short('d').Bool(),
+		check:           cmd.Flag("check", "exit with status 1 if any file would change").Bool(),
+		verify:          cmd.Flag("verify", "report files where formatting changes the document structure").Bool(),
+		files:           cmd.Arg("files", "files or directories to process, if none use stdin").Strings(),
+		lines:           cmd.Flag("lines", "only format the blocks in lines start:end, printing the edits as JSON").String(),
+		style:           cmd.Flag("style", "style preset, github, commonmark, hugo, google or one from the config").String(),
+		lineLength:      cmd.Flag("linelength", "line length, -1=unlimited").Default("70").Int(),
+		wrap:            cmd.Flag("wrap", "wrap mode, fill lines or break at each sentence or clause").Default("fill").Enum("fill", "sentence", "clause"),
+		tabWidth:        cmd.Flag("tabwidth", "width of a tab when wrapping").Default("4").Int(),
+		hrLength:        cmd.Flag("hrlength", "HR length").Default("3").Int(),
+		hrChar:          cmd.Flag("hrchar", "HR char").Default("-").String(),
+		listIndent:      cmd.Flag("listindent", "list indent, 2 spaces for fmt and 4 for fmt2 if not set").String(),
+		listBulletChar:  cmd.Flag("listbullet", "list bullet").Default("-").String(),
+		listBulletSpace: cmd.Flag("listbulletspace", "list bullet space").Default(" ").String(),
+		listNumbering:   cmd.Flag("listnumbering", "ordered list numbering").Default("sequential").Enum("sequential", "one", "preserve", "padded"),
+		headingStyle:    cmd.Flag("headingstyle", "heading style").Default("atx").Enum("atx", "setext"),
+		emphasis:        cmd.Flag("emphasis", "emphasis marker").Default("*").Enum("*", "_"),
+		strong:          cmd.Flag("strong", "strong emphasis marker, written twice").Default("*").Enum("*", "_"),
+		tableCompact:    cmd.Flag("tablecompact", "don't align table columns").Bool(),
+		renumberNotes:   cmd.Flag("renumberfootnotes", "renumber footnotes and move them to the end").Bool(),
+		hardBreak:       cmd.Flag("hardbreak", "hard line break style").Default("spaces").Enum("spaces", "backslash"),
+		linkStyle:       cmd.Flag("linkstyle", "link style, keep or convert to reference").Default("keep").Enum("keep", "reference"),
+		linkRefs:        cmd.Flag("linkrefs", "where to put reference link definitions").Default("document").Enum("document", "section"),
+		autoLinks:       cmd.Flag("autolinks", "keep bare URLs or write them as <url> autolinks").Default("keep").Enum("keep", "angle"),
+		frontMatter:     cmd.Flag("frontmatter", "keep front matter as written, normalize it, or convert it").Default("keep").Enum("keep", "normalize", "yaml", "toml", "json"),
+		lineEnding:      cmd.Flag("lineending", "keep the input's line endings or use lf or crlf").Default("keep").Enum("keep", "lf", "crlf"),
+		codeFence:       cmd.Flag("codefence", "code block fence character").Default("`").Enum("`", "~"),
+		indentedCode:    cmd.Flag("indentedcode", "convert indented code blocks to fenced or keep them (fmt2 only)").Default("fenced").Enum("fenced", "keep"),
+		codeAlias:       cmd.Flag("codealias", "language alias for code blocks, e.g. golang=go").StringMap(),
+		codeFmt:         cmd.Flag("codefmt", "external code formatter, e.g. sh='shfmt -i 2'").StringMap(),
+	}
+}
+
+// options returns the options from the config, with any command line
+// flags applied
+func (f formatFlags) options(cfg *mdtool.Config) mdtool.FmtOptions {
+	opt := cfg.FmtOptions()
+	if flagsSet["style"] {
+		var err error
+		if opt, err = cfg.StyleOptions(*f.style); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if flagsSet["linelength"] {
+		opt.LineLength = *f.lineLength
+	}
+	if flagsSet["wrap"] {
+		opt.WrapMode = *f.wrap
+	}
+	if flagsSet["tabwidth"] {
+		opt.TabWidth = *f.tabWidth
+	}
+	if flagsSet["hrchar"] {
+		opt.HrChar = *f.hrChar
+	}
+	if flagsSet["hrlength"] {
+		opt.HrLength = *f.hrLength
+	}
+	if flagsSet["listbullet"] {
+		opt.ListBulletChar = *f.listBulletChar
+	}
+	if flagsSet["listindent"] {
+		opt.ListIndent = *f.listIndent
+	}
+	if flagsSet["listbulletspace"] {
+		opt.ListBulletSpace = *f.listBulletSpace
+	}
+	if flagsSet["listnumbering"] {
+		opt.ListNumbering = *f.listNumbering
+	}
+	if flagsSet["headingstyle"] {
+		opt.HeadingStyle = *f.headingStyle
+	}
+	if flagsSet["emphasis"] {
+		opt.EmphasisChar = *f.emphasis
+	}
+	if flagsSet["strong"] {
+		opt.StrongChar = *f.strong
+	}
+	if flagsSet["tablecompact"] {
+		opt.TableCompact = *f.tableCompact
+	}
+	if flagsSet["renumberfootnotes"] {
+		opt.FootnoteRenumber = *f.renumberNotes
+	}
+	if flagsSet["hardbreak"] {
+		opt.HardBreak = *f.hardBreak
+	}
+	if flagsSet["linkstyle"] {
+		opt.LinkStyle = *f.linkStyle
+	}
+	if flagsSet["linkrefs"] {
+		opt.LinkRefPlacement = *f.linkRefs
+	}
+	if flagsSet["autolinks"] {
+		opt.AutoLinks = *f.autoLinks
+	}
+	if flagsSet["frontmatter"] {
+		opt.FrontMatterFormat = *f.frontMatter
+	}
+	if flagsSet["lineending"] {
+		opt.LineEnding = *f.lineEnding
+	}
+	if flagsSet["codefence"] {
+		opt.CodeFenceChar = *f.codeFence
+	}
+	if flagsSet["indentedcode"] {
+		opt.IndentedCode = *f.indentedCode
+	}
+	if len(*f.codeAlias) != 0 && opt.CodeAliases == nil {
+		opt.CodeAliases = make(map[string]string)
+	}
+	for name, lang := range *f.codeAlias {
+		opt.CodeAliases[name] = lang
+	}
+	for lang, command := range *f.codeFmt {
+		opt.CodeFormatters.Register(lang, mdtool.ExternalCodeFormatter(command))
+	}
+	opt.ListIndent = strings.Replace(opt.ListIndent, "\\t", "\t", -1)
+	opt.ListBulletSpace = strings.Replace(opt.ListBulletSpace, "\\t", "\t", -1)
+	return opt
+}
+
+// rangeFormatter formats part of a document, see mdtool.FmtRange
+type rangeFormatter func(src []byte, start, end int, opt *mdtool.FmtOptions) []mdtool.TextEdit
+
+// run formats stdin or each file with the given formatter
+func (f formatFlags) run(format func([]byte, *mdtool.FmtOptions) []byte, formatRange rangeFormatter) {
+	lossy := make(map[string]int) // for --verify, count by node type
+	if len(*f.files) == 0 {
+		rawin, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opt := f.options(loadConfig("."))
+		out, done := f.format("<standard input>", rawin, &opt, format, formatRange)
+		if done {
+			return
+		}
+		if *f.verify {
+			if !verifyFile("<standard input>", rawin, out, &opt, lossy) {
+				os.Exit(1)
+			}
+			return
+		}
+		if f.report("<standard input>", rawin, out) {
+			os.Exit(1)
+		}
+		return
+	}
+	files, _, err := expandFiles(*f.files)
+	if err != nil {
+		log.Fatal(err)
+	}
+	failed := false
+	for _, name := range files {
+		rawin, err := ioutil.ReadFile(name)
+		if err != nil {
+			log.Fatalf("Can't read %q: %s", name, err)
+		}
+		opt := f.options(loadConfig(name))
+		out, done := f.format(name, rawin, &opt, format, formatRange)
+		if done {
+			continue
+		}
+		if *f.verify {
+			if !verifyFile(name, rawin, out, &opt, lossy) {
+				failed = true
+			}
+			continue
+		}
+		if f.report(name, rawin, out) {
+			failed = true
+		}
+		if *f.write && !bytes.Equal(rawin, out) {
+			if err := verify(rawin, out, &opt); err != nil {
+				log.Printf("%s: not written, formatting changes the document: %s", name, err)
+				failed = true
+				continue
+			}
+			if err := ioutil.WriteFile(name, out, 0); err != nil {
+				log.Fatalf("Can't write %q: %s", name, err)
+			}
+		}
+	}
+	if len(lossy) != 0 {
+		types := make([]string, 0, len(lossy))
+		for t := range lossy {
+			types = append(types, t)
+		}
+		sort.Slice(types, func(i, j int) bool {
+			if lossy[types[i]] != lossy[types[j]] {
+				return lossy[types[i]] > lossy[types[j]]
+			}
+			return types[i] < types[j]
+		})
+		fmt.Println()
+		for _, t := range types {
+			fmt.Printf("%6d %s\n", lossy[t], t)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// format formats a file, or with --lines only part of it.  If only the
+// edits are wanted, they are printed and done is true.
+func (f formatFlags) format(name string, rawin []byte, opt *mdtool.FmtOptions, format func([]byte, *mdtool.FmtOptions) []byte, formatRange rangeFormatter) (out []byte, done bool) {
+	if *f.lines == "" {
+		if err := mdtool.FrontMatterError(rawin, opt); err != nil {
+			log.Printf("%s: front matter kept as written: %s", name, err)
+		}
+		return format(rawin, opt), false
+	}
+	start, end, err := lineOffsets(rawin, *f.lines)
+	if err != nil {
+		log.Fatalf("Bad --lines %q: %s", *f.lines, err)
+	}
+	edits := formatRange(rawin, start, end, opt)
+	if *f.write || *f.list || *f.diff || *f.check || *f.verify {
+		return mdtool.ApplyEdits(rawin, edits), false
+	}
+	if err := verify(rawin, mdtool.ApplyEdits(rawin, edits), opt); err != nil {
+		log.Printf("%s: no edits, formatting changes the document: %s", name, err)
+		edits = nil
+	}
+	if edits == nil {
+		edits = []mdtool.TextEdit{}
+	}
+	rawout, err := json.Marshal(struct {
+		File  string            `json:"file"`
+		Edits []mdtool.TextEdit `json:"edits"`
+	}{name, edits})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(string(rawout))
+	return nil, true
+}
+
+// lineOffsets converts lines "start:end", numbered from 1 and including
+// the end line, to the byte offsets of the start of the first line and
+// the end of the last.  Either number can be left out.
+func lineOffsets(src []byte, lines string) (start, end int, err error) {
+	parts := strings.Split(lines, ":")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("expected start:end")
+	}
+	first, last := 1, -1
+	if parts[0] != "" {
+		if first, err = strconv.Atoi(parts[0]); err != nil || first < 1 {
+			return 0, 0, fmt.Errorf("bad start line %q", parts[0])
+		}
+	}
+	if parts[1] != "" {
+		if last, err = strconv.Atoi(parts[1]); err != nil || last < first {
+			return 0, 0, fmt.Errorf("bad end line %q", parts[1])
+		}
+	}
+	start, end = -1, len(src)
+	row := 1
+	for offset := 0; offset <= len(src); row++ {
+		if row == first {
+			start = offset
+		}
+		i := bytes.IndexByte(src[offset:], '\n')
+		if i == -1 {
+			break
+		}
+		offset += i + 1
+		if row == last {
+			end = offset
+			break
+		}
+	}
+	if start == -1 {
+		return 0, 0, fmt.Errorf("only %d lines", row)
+	}
+	return start, end, nil
+}
+
+// verify checks formatting kept the structure of a document, allowing
+// for code reformatted by the built-in and configured formatters
+func verify(rawin, out []byte, opt *mdtool.FmtOptions) error {
+	formatters := mdtool.DefaultCodeFormatters()
+	for lang, f := range opt.CodeFormatters {
+		formatters.Register(lang, f)
+	}
+	return mdtool.VerifyCode(rawin, out, formatters)
+}
+
+// verifyFile prints where formatting changes the structure of a file,
+// counting the node type that differs.  It returns false if it changed.
+func verifyFile(name string, rawin, out []byte, opt *mdtool.FmtOptions, lossy map[string]int) bool {
+	err := verify(rawin, out, opt)
+	if err == nil {
+		return true
+	}
+	fmt.Printf("%s: %s\n", name, err)
+	if verr, ok := err.(*mdtool.VerifyError); ok {
+		path := strings.Split(verr.Path, " > ")
+		lossy[path[len(path)-1]]++
+	}
+	return false
+}
+
+// report prints the formatted output, or with -l, -d and --check what
+// would change.  It returns true if --check should fail.
+func (f formatFlags) report(name string, rawin, out []byte) bool {
+	if !*f.list && !*f.diff && !*f.check {
+		if !*f.write {
+			fmt.Println(string(out))
+		}
+		return false
+	}
+	if bytes.Equal(rawin, out) {
+		return false
+	}
+	if *f.list {
+		fmt.Println(name)
+	}
+	if *f.diff {
+		os.Stdout.Write(mdtool.Diff(name+".orig", rawin, name, out))
+	}
+	return *f.check
+}
+
+// expandFiles replaces any directories with the markdown files in them,
+// leaving out files excluded by the config.
+// hasDir is true if any directory was given.
+func expandFiles(args []string) (files []string, hasDir bool, err error) {
+	for _, arg := range args {
+		fi, err := os.Stat(arg)
+		if err != nil {
+			return nil, false, err
+		}
+		if !fi.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		hasDir = true
+		err = filepath.Walk(arg, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() && path != arg && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			switch filepath.Ext(path) {
+			case ".md", ".markdown":
+				if !info.IsDir() && loadConfig(path).Included(path) {
+					files = append(files, path)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	return files, hasDir, nil
+}
+
+// vetFailed determines if vet should exit with a non-zero status
+func vetFailed(counts map[mdtool.Severity]int, failOn string, maxWarnings int) bool {
+	if counts[mdtool.SeverityError] > 0 {
+		return true
+	}
+	if failOn == "warning" && counts[mdtool.SeverityWarning] > 0 {
+		return true
+	}
+	return maxWarnings >= 0 && counts[mdtool.SeverityWarning] > maxWarnings
+}
+
+// isTerminal returns true if the file is a character device, e.g. a TTY
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// severityLabel returns the severity name, in ANSI color if requested
+func severityLabel(s mdtool.Severity, color bool) string {
+	if !color {
+		return s.String()
+	}
+	code := "36" // cyan
+	switch s {
+	case mdtool.SeverityError:
+		code = "31" // red
+	case mdtool.SeverityWarning:
+		code = "33" // yellow
 	}
+	return "\033[" + code + "m" + s.String() + "\033[0m"
 }
@@ -0,0 +1,216 @@
+package mdtool
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"os/exec"
+	"strings"
+)
+
+// CodeFormatter formats the contents of a fenced code block.  On error
+// the block is left as-is.
+type CodeFormatter interface {
+	FormatCode(code []byte) ([]byte, error)
+}
+
+// CodeFormatterFunc lets a function be used as a CodeFormatter
+type CodeFormatterFunc func(code []byte) ([]byte, error)
+
+// FormatCode calls f(code)
+func (f CodeFormatterFunc) FormatCode(code []byte) ([]byte, error) {
+	return f(code)
+}
+
+// CodeFormatters maps the language in a fence info string, e.g. "go" in
+// ```go, to the formatter for it.
+type CodeFormatters map[string]CodeFormatter
+
+// DefaultCodeFormatters returns the built-in formatters, for Go, JSON,
+// Markdown and HCL.
+func DefaultCodeFormatters() CodeFormatters {
+	goFmt := CodeFormatterFunc(format.Source)
+	jsonFmt := CodeFormatterFunc(formatJSON)
+	hclFmt := CodeFormatterFunc(formatHCL)
+	mdFmt := CodeFormatterFunc(func(code []byte) ([]byte, error) {
+		return Fmt(code, nil), nil
+	})
+	return CodeFormatters{
+		"go":        goFmt,
+		"golang":    goFmt,
+		"json":      jsonFmt,
+		"markdown":  mdFmt,
+		"md":        mdFmt,
+		"hcl":       hclFmt,
+		"terraform": hclFmt,
+		"tf":        hclFmt,
+	}
+}
+
+// Register adds or replaces the formatter for a language
+func (c CodeFormatters) Register(lang string, f CodeFormatter) {
+	c[strings.ToLower(lang)] = f
+}
+
+// Format formats code using the language from the fence info string.
+// If there is no formatter for the language, the code is returned
+// unchanged.
+func (c CodeFormatters) Format(info string, code []byte) ([]byte, error) {
+	f := c[codeLang(info)]
+	if f == nil {
+		return code, nil
+	}
+	out, err := f.FormatCode(code)
+	if err != nil {
+		return code, err
+	}
+	if len(out) != 0 && out[len(out)-1] != '\n' {
+		out = append(out, '\n')
+	}
+	return out, nil
+}
+
+// codeFormatters returns the formatters from the options, with Markdown
+// formatted by the same formatter and options as the document.
+func codeFormatters(opt *FmtOptions, fmtMarkdown func([]byte, *FmtOptions) []byte) CodeFormatters {
+	c := DefaultCodeFormatters()
+	mdFmt := CodeFormatterFunc(func(code []byte) ([]byte, error) {
+		return fmtMarkdown(code, opt), nil
+	})
+	c["markdown"], c["md"] = mdFmt, mdFmt
+	if opt != nil {
+		for lang, f := range opt.CodeFormatters {
+			c.Register(lang, f)
+		}
+	}
+	return c
+}
+
+// codeLang returns the language from a fence info string, e.g.
+// "go" from "go" or ".go {linenos=true}"
+func codeLang(info string) string {
+	for _, elt := range strings.Fields(info) {
+		elt = strings.TrimPrefix(elt, ".")
+		if len(elt) != 0 {
+			return strings.ToLower(elt)
+		}
+	}
+	return ""
+}
+
+// codeFormatFaults reports fenced code blocks that fail to format
+func codeFormatFaults(raw []byte, faults []Fault, formatters CodeFormatters) []Fault {
+	for _, b := range fencedCodeBlocks(raw) {
+		if _, err := formatters.Format(b.info, b.code); err != nil {
+			faults = append(faults, Fault{
+				Offset: b.start,
+				Reason: FaultCodeFormat,
+			})
+		}
+	}
+	return faults
+}
+
+// ExternalCodeFormatter runs a command, such as "shfmt -i 2", with the
+// code on stdin and reads the formatted code from stdout.
+func ExternalCodeFormatter(command string) CodeFormatter {
+	args := strings.Fields(command)
+	return CodeFormatterFunc(func(code []byte) ([]byte, error) {
+		if len(args) == 0 {
+			return nil, fmt.Errorf("no command")
+		}
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdin = bytes.NewReader(code)
+		stderr := bytes.Buffer{}
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return nil, fmt.Errorf("%s: %s", args[0], msg)
+			}
+			return nil, fmt.Errorf("%s: %s", args[0], err)
+		}
+		return out, nil
+	})
+}
+
+// formatJSON re-indents JSON with two spaces
+func formatJSON(code []byte) ([]byte, error) {
+	out := bytes.Buffer{}
+	if err := json.Indent(&out, bytes.TrimSpace(code), "", "  "); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// formatHCL re-indents HCL-like config by nesting of braces and
+// brackets.  Heredocs and comments are left alone.
+func formatHCL(code []byte) ([]byte, error) {
+	out := bytes.Buffer{}
+	depth := 0
+	heredoc := ""
+	for _, line := range strings.Split(strings.TrimRight(string(code), "\n"), "\n") {
+		text := strings.TrimSpace(line)
+		if heredoc != "" {
+			out.WriteString(line + "\n")
+			if text == heredoc {
+				heredoc = ""
+			}
+			continue
+		}
+		if text == "" {
+			out.WriteByte('\n')
+			continue
+		}
+		opens, closes, leading := hclNesting(text)
+		indent := depth - leading
+		if indent < 0 {
+			return nil, fmt.Errorf("unbalanced %q", text)
+		}
+		out.WriteString(strings.Repeat("  ", indent) + text + "\n")
+		depth += opens - closes
+		if i := strings.Index(text, "<<"); i != -1 {
+			heredoc = strings.TrimLeft(text[i+2:], "-~")
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced braces")
+	}
+	return out.Bytes(), nil
+}
+
+// hclNesting counts the braces and brackets opened and closed on a line,
+// outside of strings and comments.  leading is the number closed at the
+// start of the line, which are dedented.
+func hclNesting(text string) (opens, closes, leading int) {
+	inString := false
+	start := true
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case inString:
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '#' || strings.HasPrefix(text[i:], "//"):
+			return
+		case c == '{' || c == '[' || c == '(':
+			opens++
+		case c == '}' || c == ']' || c == ')':
+			closes++
+			if start {
+				leading++
+			}
+			continue
+		}
+		if c != ' ' && c != '\t' {
+			start = false
+		}
+	}
+	return
+}
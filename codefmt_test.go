@@ -0,0 +1,98 @@
+package mdtool
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCodeFormatters(t *testing.T) {
+	cases := []struct {
+		info string
+		code string
+		want string
+		fail bool
+	}{
+		{"go", "package main\nfunc main() {\nx:=1\n_ = x\n}\n",
+			"package main\n\nfunc main() {\n\tx := 1\n\t_ = x\n}\n", false},
+		{".golang {linenos=true}", "x:=1\n", "x := 1\n", false},
+		{"go", "func {\n", "func {\n", true},
+		{"json", `{"a":[1,2]}`, "{\n  \"a\": [\n    1,\n    2\n  ]\n}\n", false},
+		{"JSON", "{\"a\":\n", "{\"a\":\n", true},
+		{"hcl", "resource \"x\" \"y\" {\nname = \"}\"\ntags = {\na = 1\n}\n}\n",
+			"resource \"x\" \"y\" {\n  name = \"}\"\n  tags = {\n    a = 1\n  }\n}\n", false},
+		{"tf", "a {\n", "a {\n", true},
+		{"markdown", "#  title\n", "# title\n", false},
+		{"text", "x:=1\n", "x:=1\n", false},
+		{"", "x:=1\n", "x:=1\n", false},
+	}
+	c := DefaultCodeFormatters()
+	for idx, tt := range cases {
+		got, err := c.Format(tt.info, []byte(tt.code))
+		if (err != nil) != tt.fail {
+			t.Errorf("Case %d) expected failure %v, got %v", idx, tt.fail, err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("Case %d)\n%q\n%q", idx, tt.want, got)
+		}
+	}
+}
+
+func TestCodeFormattersExternal(t *testing.T) {
+	opt := &FmtOptions{
+		LineLength: 70,
+		CodeFormatters: CodeFormatters{
+			"sh": ExternalCodeFormatter("no-such-command-mdtool"),
+		},
+	}
+	src := "```sh\nif true;then echo;fi\n```\n"
+	for _, format := range []func([]byte, *FmtOptions) []byte{Fmt, Fmt2} {
+		if got := string(format([]byte(src), opt)); strings.TrimSpace(got) != strings.TrimSpace(src) {
+			t.Errorf("failed formatter changed the block:\n%q", got)
+		}
+	}
+
+	src = "```json\n{\"a\":1}\n```\n"
+	want := "```json\n{\n  \"a\": 1\n}\n```"
+	for _, format := range []func([]byte, *FmtOptions) []byte{Fmt, Fmt2} {
+		if got := strings.TrimSpace(string(format([]byte(src), opt))); got != want {
+			t.Errorf("expected:\n%q\ngot:\n%q", want, got)
+		}
+	}
+}
+
+func TestVetCodeFormat(t *testing.T) {
+	src := "# t\n\n```go\nfunc {\n```\n\n```json\n{}\n```\n"
+	if faults := Vet([]byte(src), nil); len(faults) != 0 {
+		t.Errorf("code checked without CodeFormatters: %v", faults)
+	}
+	faults := Vet([]byte(src), &VetOptions{CodeFormatters: DefaultCodeFormatters()})
+	if len(faults) != 1 || faults[0].Reason != FaultCodeFormat || faults[0].Row != 3 {
+		t.Errorf("expected one code format fault on line 3, got %+v", faults)
+	}
+
+	// the info string and code of other fences
+	cases := []struct {
+		src  string
+		rows []int
+	}{
+		{"~~~go\nfunc {\n~~~\n\n~~~ json\n{}\n~~~\n", []int{1}},
+		{"- item\n\n  ```json\n  {\"a\":\n  ```\n", []int{3}},
+		{"> ```json\n> {}\n> ```\n\n> ````go\n> ```\n> func {\n> ````\n", []int{5}},
+		{"````go\n```\npackage main\n````\n", []int{1}},
+		// a fence in indented code is code
+		{"text\n\n    ```\n    x\n\n```json\n{\n```\n", []int{6}},
+		{"- item\n\n          ```\n\n  ```json\n  {\n  ```\n", []int{5}},
+	}
+	for idx, tt := range cases {
+		var rows []int
+		for _, f := range Vet([]byte(tt.src), &VetOptions{CodeFormatters: DefaultCodeFormatters()}) {
+			if f.Reason == FaultCodeFormat {
+				rows = append(rows, f.Row)
+			}
+		}
+		if !reflect.DeepEqual(rows, tt.rows) {
+			t.Errorf("Case %d) %q: expected code format faults on lines %v, got %v", idx, tt.src, tt.rows, rows)
+		}
+	}
+}
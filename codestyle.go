@@ -0,0 +1,224 @@
+package mdtool
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	bf "gopkg.in/russross/blackfriday.v2"
+)
+
+// Blackfriday only reads the first word of a code block's info string.
+// v1 drops the rest, and v2 doesn't see a fence at all if anything
+// follows the language, as in ```go {linenos=true}.  Such info strings
+// are replaced with a placeholder word before parsing, which the
+// renderers look up, and any placeholder left in the output is put
+// back as written.
+
+var codeFence = regexp.MustCompile("^(`{3,}|~{3,})[ \t]*([^\r\n]*?)[ \t]*\r?\n?$")
+
+func codeInfoPlaceholder(i int) string {
+	return placeholder("INFO", i)
+}
+
+// extractCodeInfo replaces info strings of more than one word with a
+// placeholder.
+func extractCodeInfo(src []byte) ([]byte, []string) {
+	var infos []string
+	out := bytes.Buffer{}
+	fence := ""
+	for offset := 0; offset < len(src); {
+		line := string(nextLine(src, offset))
+		offset += len(line)
+
+		// skip any block quote markers and list indent
+		text := strings.TrimLeft(line, " \t")
+		for strings.HasPrefix(text, ">") {
+			text = strings.TrimLeft(text[1:], " \t")
+		}
+		m := codeFence.FindStringSubmatch(text)
+		switch {
+		case m == nil:
+		case fence != "":
+			if m[2] == "" && m[1][0] == fence[0] && len(m[1]) >= len(fence) {
+				fence = ""
+			}
+		case m[1][0] == '`' && strings.Contains(m[2], "`"):
+			// not a fence
+		default:
+			fence = m[1]
+			info := m[2]
+			if strings.ContainsAny(info, " \t{") {
+				start := len(line) - len(text) + strings.Index(text, info)
+				line = line[:start] + codeInfoPlaceholder(len(infos)) + line[start+len(info):]
+				infos = append(infos, info)
+			}
+		}
+		out.WriteString(line)
+	}
+	return out.Bytes(), infos
+}
+
+// restoreCodeInfo puts back info strings the renderer didn't use
+func restoreCodeInfo(out []byte, infos []string) []byte {
+	for i, info := range infos {
+		out = bytes.Replace(out, []byte(codeInfoPlaceholder(i)), []byte(info), -1)
+	}
+	return out
+}
+
+// defaultCodeAliases map other names for a language to the usual one.
+// Others, such as sh to bash, can be set with FmtOptions.CodeAliases.
+var defaultCodeAliases = map[string]string{
+	"golang": "go",
+}
+
+// codeStyle writes the fences and info strings of code blocks
+type codeStyle struct {
+	fenceChar byte
+	aliases   map[string]string
+	infos     []string // info strings replaced by placeholders
+}
+
+func newCodeStyle(opt *FmtOptions) *codeStyle {
+	c := &codeStyle{fenceChar: '`', aliases: make(map[string]string)}
+	if opt != nil && opt.CodeFenceChar == "~" {
+		c.fenceChar = '~'
+	}
+	for k, v := range defaultCodeAliases {
+		c.aliases[k] = v
+	}
+	if opt != nil {
+		for k, v := range opt.CodeAliases {
+			c.aliases[strings.ToLower(k)] = v
+		}
+	}
+	return c
+}
+
+// Info returns the info string of a code block with the language name
+// normalized: no leading "." and aliases replaced.  Anything after the
+// language is kept, as is an info string of only {attributes}.
+func (c *codeStyle) Info(info string) string {
+	for i := range c.infos {
+		if info == codeInfoPlaceholder(i) {
+			info = c.infos[i]
+			break
+		}
+	}
+	info = strings.TrimSpace(info)
+	if info == "" || info[0] == '{' {
+		return info
+	}
+	lang, rest := info, ""
+	if i := strings.IndexAny(info, " \t{"); i != -1 {
+		lang, rest = info[:i], strings.TrimSpace(info[i:])
+	}
+	lang = strings.TrimPrefix(lang, ".")
+	if alias, ok := c.aliases[strings.ToLower(lang)]; ok {
+		lang = alias
+	}
+	if lang == "" || rest == "" {
+		return lang + rest
+	}
+	return lang + " " + rest
+}
+
+// Fence returns the fence for a code block, longer than any run of the
+// fence character in the code.  Backticks can't be used if the info
+// string has one.
+func (c *codeStyle) Fence(info string, code []byte) string {
+	char := c.fenceChar
+	if char == '`' && strings.Contains(info, "`") {
+		char = '~'
+	}
+	longest, run := 0, 0
+	for _, b := range code {
+		if b != char {
+			run = 0
+			continue
+		}
+		run++
+		if run > longest {
+			longest = run
+		}
+	}
+	if longest < 3 {
+		longest = 2
+	}
+	return strings.Repeat(string(char), longest+1)
+}
+
+// Write writes a fenced code block, with a newline after the closing
+// fence.  code must end with a newline.
+func (c *codeStyle) Write(out *bytes.Buffer, info string, code []byte) {
+	fence := c.Fence(info, code)
+	out.WriteString(fence + info + "\n")
+	out.Write(code)
+	out.WriteString(fence + "\n")
+}
+
+// Blackfriday v2.0.0 reads a line of fenced code in a list item that
+// starts like a list item or heading as one, which ends the code block.
+// Fmt2 marks such lines before parsing, and the renderer removes the
+// mark again.  If the marks didn't all end up in code, the fences were
+// found wrong and the source is parsed as it is.
+
+var codeListLine = regexp.MustCompile(`^(?:[-+*:]|[0-9]+\.)[ \t]|^#`)
+
+// codeLineMarker is put before lines of code that look like list items
+// or headings
+var codeLineMarker = placeholder("CODELINE", 0)
+
+// markCodeLines marks the lines of fenced code that blackfriday might
+// take for list items or headings
+func markCodeLines(src []byte) []byte {
+	out := bytes.Buffer{}
+	fence := ""
+	for offset := 0; offset < len(src); {
+		line := string(nextLine(src, offset))
+		offset += len(line)
+
+		// skip any block quote markers and list indent
+		text := strings.TrimLeft(line, " \t")
+		for strings.HasPrefix(text, ">") {
+			text = strings.TrimLeft(text[1:], " \t")
+		}
+		m := codeFence.FindStringSubmatch(text)
+		switch {
+		case m == nil:
+			if fence != "" && codeListLine.MatchString(text) {
+				line = line[:len(line)-len(text)] + codeLineMarker + text
+			}
+		case fence != "":
+			if m[2] == "" && m[1][0] == fence[0] && len(m[1]) >= len(fence) {
+				fence = ""
+			}
+		case m[1][0] == '`' && strings.Contains(m[2], "`"):
+			// not a fence
+		default:
+			fence = m[1]
+		}
+		out.WriteString(line)
+	}
+	return out.Bytes()
+}
+
+// unmarkCode removes the marks markCodeLines put on lines of code
+func unmarkCode(code []byte) []byte {
+	return bytes.Replace(code, []byte(codeLineMarker), nil, -1)
+}
+
+// marksInCode is true if all the marks markCodeLines put are in code
+// blocks
+func marksInCode(doc *bf.Node) bool {
+	ok := true
+	doc.Walk(func(node *bf.Node, entering bool) bf.WalkStatus {
+		if node.Type != bf.CodeBlock && bytes.Contains(node.Literal, []byte(codeLineMarker)) {
+			ok = false
+			return bf.Terminate
+		}
+		return bf.GoToNext
+	})
+	return ok
+}
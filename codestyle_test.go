@@ -0,0 +1,43 @@
+package mdtool
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFmtCodeStyle(t *testing.T) {
+	cases := []struct {
+		src   string
+		fence string
+		want  string
+	}{
+		{"```golang\nx := 1\n```\n", "", "```go\nx := 1\n```"},
+		{"```.sh\nls\n```\n", "", "```bash\nls\n```"},
+		{"```go {linenos=true}\nx := 1\n```\n", "", "```go {linenos=true}\nx := 1\n```"},
+		{"```{.go linenos=true}\nx := 1\n```\n", "", "```{.go linenos=true}\nx := 1\n```"},
+		{"    indented\n", "", "```\nindented\n```"},
+		{"    a ``` b\n", "", "````\na ``` b\n````"},
+		{"```text\nx\n```\n", "~", "~~~text\nx\n~~~"},
+		{"```text\na ~~~~ b\n```\n", "~", "~~~~~text\na ~~~~ b\n~~~~~"},
+		{"~~~ a`b\nx\n~~~\n", "", "~~~a`b\nx\n~~~"},
+		{"text\n\n    ```go a b\n", "", "text\n\n````\n```go a b\n````"},
+	}
+	for idx, tt := range cases {
+		opt := &FmtOptions{LineLength: 70, CodeFenceChar: tt.fence, CodeAliases: map[string]string{"SH": "bash"}}
+		for _, format := range []func([]byte, *FmtOptions) []byte{Fmt, Fmt2} {
+			got := strings.TrimSpace(string(format([]byte(tt.src), opt)))
+			if got != tt.want {
+				t.Errorf("Case %d)\n%q\n%q", idx, tt.want, got)
+			}
+		}
+	}
+}
+
+func TestFmt2IndentedCode(t *testing.T) {
+	src := "text\n\n    a\n\n    b\n\n```go\nc\n```\n"
+	want := "text\n\n    a\n\n    b\n\n```go\nc\n```"
+	got := strings.TrimSpace(string(Fmt2([]byte(src), &FmtOptions{LineLength: 70, IndentedCode: "keep"})))
+	if got != want {
+		t.Errorf("\n%q\n%q", want, got)
+	}
+}
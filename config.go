@@ -0,0 +1,588 @@
+package mdtool
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ConfigFiles are the names of config files, looked for in the
+// directory of each file and every parent directory.  Settings in
+// nearer files replace those from further up.  A file with
+// "root: true" stops the search.
+var ConfigFiles = []string{".mdtools.yaml", ".mdtools.yml", ".mdtools.toml"}
+
+// Config is the effective configuration for a file.
+//
+// A config file looks like:
+//
+//	fmt:
+//	  style: github
+//	  line_length: 80
+//	  list_bullet: "*"
+//	  code_formatters:
+//	    sh: shfmt -i 2
+//	vet:
+//	  disable: [naked-url-parens]
+//	  severity:
+//	    orphan-page: error
+//	exclude: ["vendor", "**/CHANGELOG.md"]
+//	overrides:
+//	  - path: docs/api
+//	    fmt:
+//	      line_length: -1
+//
+// A style is a preset of fmt settings, either github, commonmark, hugo
+// or google, or one defined under styles:
+//
+//	styles:
+//	  team:
+//	    list_bullet: "*"
+//	    wrap: sentence
+//
+// Other fmt settings in the same file win over the style.
+//
+// Include, exclude and override paths are globs relative to the
+// directory of the config file, where ** matches any number of
+// directories.  A pattern matching a directory matches everything in it.
+type Config struct {
+	// Files are the config files used, furthest first
+	Files []string
+
+	values map[string]interface{}
+}
+
+// defaultConfig is the configuration with no config files.
+// It matches the defaults of the md command.
+func defaultConfig() map[string]interface{} {
+	return map[string]interface{}{
+		"fmt": map[string]interface{}{
+			"style":              "",
+			"line_length":        70,
+			"wrap":               "fill",
+			"tab_width":          4,
+			"list_indent":        "", // 2 spaces for fmt, 4 for fmt2
+			"list_bullet":        "-",
+			"list_bullet_space":  " ",
+			"list_numbering":     "sequential",
+			"heading_style":      "atx",
+			"emphasis":           "*",
+			"strong":             "*",
+			"hr_char":            "-",
+			"hr_length":          3,
+			"table_compact":      false,
+			"hard_break":         "spaces",
+			"footnote_renumber":  false,
+			"link_style":         "keep",
+			"link_ref_placement": "document",
+			"auto_links":         "keep",
+			"front_matter":       "keep",
+			"line_ending":        "keep",
+			"code_fence":         "`",
+			"indented_code":      "fenced",
+			"code_aliases":       map[string]interface{}{},
+			"code_formatters":    map[string]interface{}{},
+		},
+		"vet": map[string]interface{}{
+			"disable":    []interface{}{},
+			"severity":   map[string]interface{}{},
+			"entry":      []interface{}{"README.md"},
+			"check_code": false,
+		},
+		"styles":  map[string]interface{}{},
+		"include": []interface{}{},
+		"exclude": []interface{}{},
+	}
+}
+
+// LoadConfig finds the config files for a file or directory and merges
+// them.  If no config file sets a line length or line ending,
+// max_line_length or end_of_line from .editorconfig is used.
+func LoadConfig(name string) (*Config, error) {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return nil, err
+	}
+	dir := abs
+	if fi, err := os.Stat(abs); err != nil || !fi.IsDir() {
+		dir = filepath.Dir(abs)
+	}
+
+	// find and read the files, nearest first
+	var files []string
+	var values []map[string]interface{}
+	for d := dir; ; d = filepath.Dir(d) {
+		found := ""
+		for _, base := range ConfigFiles {
+			f := filepath.Join(d, base)
+			if _, err := os.Stat(f); err == nil {
+				found = f
+				break
+			}
+		}
+		if found != "" {
+			raw, err := readConfigFile(found)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, found)
+			values = append(values, raw)
+			if root, _ := raw["root"].(bool); root {
+				break
+			}
+		}
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+
+	c := &Config{values: defaultConfig()}
+	if n, ok := editorConfigLineLength(abs); ok {
+		c.values["fmt"].(map[string]interface{})["line_length"] = n
+	}
+	if v, ok := editorConfigValue(abs, "end_of_line"); ok && (v == "lf" || v == "crlf") {
+		c.values["fmt"].(map[string]interface{})["line_ending"] = v
+	}
+	target := filepath.ToSlash(abs)
+	for i := len(files) - 1; i >= 0; i-- {
+		raw := values[i]
+		base := filepath.ToSlash(filepath.Dir(files[i]))
+		overrides, _ := raw["overrides"].([]interface{})
+		delete(raw, "overrides")
+		delete(raw, "root")
+		resolvePatterns(raw, base)
+		mergeConfig(c.values, raw)
+		if err := c.applyStyle(raw); err != nil {
+			return nil, fmt.Errorf("%s: %s", files[i], err)
+		}
+		for _, o := range overrides {
+			o, ok := o.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s: overrides must be a list of mappings", files[i])
+			}
+			pattern, _ := o["path"].(string)
+			if pattern == "" {
+				return nil, fmt.Errorf("%s: override without a path", files[i])
+			}
+			if !globMatch(path.Join(base, pattern), target) {
+				continue
+			}
+			delete(o, "path")
+			resolvePatterns(o, base)
+			mergeConfig(c.values, o)
+			if err := c.applyStyle(o); err != nil {
+				return nil, fmt.Errorf("%s: %s", files[i], err)
+			}
+		}
+		c.Files = append(c.Files, files[i])
+	}
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func readConfigFile(name string) (map[string]interface{}, error) {
+	src, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if strings.HasSuffix(name, ".toml") {
+		m, err = parseTOML(src)
+	} else {
+		m, err = parseYAML(src)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	return m, nil
+}
+
+// resolvePatterns makes include and exclude patterns absolute
+func resolvePatterns(m map[string]interface{}, base string) {
+	for _, key := range []string{"include", "exclude"} {
+		switch v := m[key].(type) {
+		case string:
+			m[key] = []interface{}{path.Join(base, v)}
+		case []interface{}:
+			for i, p := range v {
+				if s, ok := p.(string); ok {
+					v[i] = path.Join(base, s)
+				}
+			}
+		}
+	}
+}
+
+// mergeConfig copies src into dst.  Mappings are merged, anything else
+// is replaced.
+func mergeConfig(dst, src map[string]interface{}) {
+	for k, v := range src {
+		if s, ok := v.(map[string]interface{}); ok {
+			if d, ok := dst[k].(map[string]interface{}); ok {
+				mergeConfig(d, s)
+				continue
+			}
+		}
+		dst[k] = v
+	}
+}
+
+// check makes sure every setting is known and the right type, so a
+// typo doesn't silently do nothing.
+func (c *Config) check() error {
+	defaults := defaultConfig()
+	for k, v := range c.values {
+		def, ok := defaults[k]
+		if !ok {
+			return fmt.Errorf("unknown setting %q", k)
+		}
+		section, ok := def.(map[string]interface{})
+		if !ok || k == "include" || k == "exclude" {
+			continue
+		}
+		if k == "styles" {
+			// each style is a set of fmt settings
+			styles, ok := v.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("styles must be a mapping")
+			}
+			fmtSettings := defaultConfig()["fmt"].(map[string]interface{})
+			delete(fmtSettings, "style")
+			for name, style := range styles {
+				if err := checkSection("styles."+name, fmtSettings, style); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := checkSection(k, section, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkSection checks the settings of a section against the defaults
+func checkSection(name string, defaults map[string]interface{}, v interface{}) error {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%s must be a mapping", name)
+	}
+	for key, value := range m {
+		d, ok := defaults[key]
+		if !ok {
+			return fmt.Errorf("unknown setting %q", name+"."+key)
+		}
+		if !sameKind(d, value) {
+			return fmt.Errorf("%s.%s: bad value %v", name, key, value)
+		}
+	}
+	return nil
+}
+
+func sameKind(a, b interface{}) bool {
+	switch a.(type) {
+	case int:
+		_, ok := b.(int)
+		return ok
+	case bool:
+		_, ok := b.(bool)
+		return ok
+	case string:
+		_, ok := b.(string)
+		return ok
+	case []interface{}:
+		_, ok := b.([]interface{})
+		return ok
+	case map[string]interface{}:
+		_, ok := b.(map[string]interface{})
+		return ok
+	}
+	return false
+}
+
+func (c *Config) section(name string) map[string]interface{} {
+	m, _ := c.values[name].(map[string]interface{})
+	return m
+}
+
+func stringList(v interface{}) []string {
+	list, _ := v.([]interface{})
+	out := make([]string, 0, len(list))
+	for _, item := range list {
+		out = append(out, fmt.Sprint(item))
+	}
+	return out
+}
+
+func stringMap(v interface{}) map[string]string {
+	m, _ := v.(map[string]interface{})
+	out := make(map[string]string, len(m))
+	for k, item := range m {
+		out[k] = fmt.Sprint(item)
+	}
+	return out
+}
+
+// FmtOptions returns the formatting options
+func (c *Config) FmtOptions() FmtOptions {
+	return fmtOptions(c.section("fmt"))
+}
+
+// fmtOptions converts fmt settings to options
+func fmtOptions(m map[string]interface{}) FmtOptions {
+	code := make(CodeFormatters)
+	for lang, command := range stringMap(m["code_formatters"]) {
+		code.Register(lang, ExternalCodeFormatter(command))
+	}
+	return FmtOptions{
+		LineLength:        m["line_length"].(int),
+		WrapMode:          m["wrap"].(string),
+		TabWidth:          m["tab_width"].(int),
+		ListIndent:        m["list_indent"].(string),
+		ListBulletChar:    m["list_bullet"].(string),
+		ListBulletSpace:   m["list_bullet_space"].(string),
+		ListNumbering:     m["list_numbering"].(string),
+		HeadingStyle:      m["heading_style"].(string),
+		EmphasisChar:      m["emphasis"].(string),
+		StrongChar:        m["strong"].(string),
+		HrChar:            m["hr_char"].(string),
+		HrLength:          m["hr_length"].(int),
+		TableCompact:      m["table_compact"].(bool),
+		HardBreak:         m["hard_break"].(string),
+		FootnoteRenumber:  m["footnote_renumber"].(bool),
+		LinkStyle:         m["link_style"].(string),
+		LinkRefPlacement:  m["link_ref_placement"].(string),
+		AutoLinks:         m["auto_links"].(string),
+		FrontMatterFormat: m["front_matter"].(string),
+		LineEnding:        m["line_ending"].(string),
+		CodeFenceChar:     m["code_fence"].(string),
+		IndentedCode:      m["indented_code"].(string),
+		CodeAliases:       stringMap(m["code_aliases"]),
+		CodeFormatters:    code,
+	}
+}
+
+// VetOptions returns the vet options
+func (c *Config) VetOptions() (*VetOptions, error) {
+	m := c.section("vet")
+	opt := &VetOptions{
+		Severity:    make(map[FaultType]Severity),
+		Disable:     make(map[FaultType]bool),
+		EntryPoints: stringList(m["entry"]),
+	}
+	for _, name := range stringList(m["disable"]) {
+		ft := ParseFaultType(name)
+		if ft == FaultZero {
+			return nil, fmt.Errorf("unknown vet rule %q", name)
+		}
+		opt.Disable[ft] = true
+	}
+	for name, level := range stringMap(m["severity"]) {
+		ft := ParseFaultType(name)
+		if ft == FaultZero {
+			return nil, fmt.Errorf("unknown vet rule %q", name)
+		}
+		sev, err := ParseSeverity(level)
+		if err != nil {
+			return nil, fmt.Errorf("bad severity for %q: %s", name, err)
+		}
+		opt.Severity[ft] = sev
+	}
+	if m["check_code"].(bool) {
+		opt.CodeFormatters = DefaultCodeFormatters()
+		for lang, command := range stringMap(c.section("fmt")["code_formatters"]) {
+			opt.CodeFormatters.Register(lang, ExternalCodeFormatter(command))
+		}
+	}
+	return opt, nil
+}
+
+// Included returns false if the file is excluded, or there are include
+// patterns and it matches none of them.
+func (c *Config) Included(name string) bool {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return true
+	}
+	target := filepath.ToSlash(abs)
+	for _, p := range stringList(c.values["exclude"]) {
+		if globMatch(p, target) {
+			return false
+		}
+	}
+	include := stringList(c.values["include"])
+	for _, p := range include {
+		if globMatch(p, target) {
+			return true
+		}
+	}
+	return len(include) == 0
+}
+
+// Bytes returns the effective configuration as YAML
+func (c *Config) Bytes() []byte {
+	buf := bytes.Buffer{}
+	writeYAML(&buf, c.values, "")
+	return buf.Bytes()
+}
+
+// globMatch matches a slash separated path against a pattern, or any
+// of the directories the path is in.
+func globMatch(pattern, name string) bool {
+	re, err := globRegexp(pattern)
+	if err != nil {
+		return false
+	}
+	for {
+		if re.MatchString(name) {
+			return true
+		}
+		parent := path.Dir(name)
+		if parent == name || parent == "." {
+			return false
+		}
+		name = parent
+	}
+}
+
+// globRegexp converts a glob to a regexp.  * and ? don't match /, **
+// matches anything, and {a,b} matches either a or b.
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	re := strings.Builder{}
+	re.WriteByte('^')
+	inBrace := false
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			re.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		case c == '{':
+			re.WriteByte('(')
+			inBrace = true
+		case c == '}' && inBrace:
+			re.WriteByte(')')
+			inBrace = false
+		case c == ',' && inBrace:
+			re.WriteByte('|')
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteByte('$')
+	return regexp.Compile(re.String())
+}
+
+// editorConfigLineLength looks up max_line_length in the .editorconfig
+// files for a file.  "off" is returned as -1, no limit.
+func editorConfigLineLength(abs string) (int, bool) {
+	value, found := editorConfigValue(abs, "max_line_length")
+	if !found {
+		return 0, false
+	}
+	if value == "off" {
+		return -1, true
+	}
+	n, err := strconv.Atoi(value)
+	return n, err == nil
+}
+
+// editorConfigValue looks up a property in the .editorconfig files for
+// a file
+func editorConfigValue(abs string, key string) (string, bool) {
+	// nearest first
+	var files [][]editorConfigSection
+	var dirs []string
+	for d := filepath.Dir(abs); ; d = filepath.Dir(d) {
+		sections, root, err := readEditorConfig(filepath.Join(d, ".editorconfig"))
+		if err == nil {
+			files = append(files, sections)
+			dirs = append(dirs, filepath.ToSlash(d))
+			if root {
+				break
+			}
+		}
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+	value, found := "", false
+	target := filepath.ToSlash(abs)
+	for i := len(files) - 1; i >= 0; i-- {
+		base := dirs[i]
+		for _, s := range files[i] {
+			pattern := s.glob
+			if strings.Contains(pattern, "/") {
+				pattern = path.Join(base, strings.TrimPrefix(pattern, "/"))
+			} else {
+				pattern = path.Join(base, "**", pattern)
+			}
+			if v, ok := s.values[key]; ok && globMatch(pattern, target) {
+				value, found = v, true
+			}
+		}
+	}
+	return value, found
+}
+
+type editorConfigSection struct {
+	glob   string
+	values map[string]string
+}
+
+// readEditorConfig reads the sections of an .editorconfig file, and
+// whether it has root = true.
+func readEditorConfig(name string) ([]editorConfigSection, bool, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+	var sections []editorConfigSection
+	root := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || line[0] == '#' || line[0] == ';':
+			continue
+		case line[0] == '[' && strings.HasSuffix(line, "]"):
+			sections = append(sections, editorConfigSection{
+				glob:   line[1 : len(line)-1],
+				values: make(map[string]string),
+			})
+		default:
+			i := strings.IndexByte(line, '=')
+			if i == -1 {
+				continue
+			}
+			key := strings.ToLower(strings.TrimSpace(line[:i]))
+			value := strings.ToLower(strings.TrimSpace(line[i+1:]))
+			if len(sections) == 0 {
+				if key == "root" {
+					root = value == "true"
+				}
+				continue
+			}
+			sections[len(sections)-1].values[key] = value
+		}
+	}
+	return sections, root, scanner.Err()
+}
@@ -0,0 +1,185 @@
+package mdtool
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// Config files and front matter are read with the YAML and TOML
+// parsers into the same map[string]interface{} form, holding nested
+// maps, []interface{}, string, int, float64, datetime, bool or nil.
+
+// datetime is an unquoted date or time, kept as written
+type datetime string
+
+// parseYAML parses a YAML document, which must be a mapping
+func parseYAML(src []byte) (map[string]interface{}, error) {
+	var v yamlValue
+	if err := yaml.Unmarshal(src, &v); err != nil {
+		return nil, err
+	}
+	switch m := v.value.(type) {
+	case nil:
+		return map[string]interface{}{}, nil
+	case map[string]interface{}:
+		return m, nil
+	}
+	return nil, fmt.Errorf("expected a mapping at the top level")
+}
+
+// yamlValue reads a YAML value into the config form.  Decoded as
+// interface{}, yaml.v2 gives dates as strings and mappings with
+// interface{} keys.
+type yamlValue struct {
+	value interface{}
+}
+
+func (y *yamlValue) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var m map[string]yamlValue
+	if err := unmarshal(&m); err == nil {
+		values := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			values[k] = v.value
+		}
+		y.value = values
+		return nil
+	}
+	var list []yamlValue
+	if err := unmarshal(&list); err == nil {
+		values := make([]interface{}, len(list))
+		for i, v := range list {
+			values[i] = v.value
+		}
+		y.value = values
+		return nil
+	}
+	// only an unquoted date can be read as a time
+	var t time.Time
+	if err := unmarshal(&t); err == nil {
+		var text string
+		if err := unmarshal(&text); err != nil {
+			return err
+		}
+		y.value = datetime(text)
+		return nil
+	}
+	return unmarshal(&y.value)
+}
+
+// parseTOML parses a TOML document
+func parseTOML(src []byte) (map[string]interface{}, error) {
+	var m map[string]interface{}
+	if _, err := toml.Decode(string(src), &m); err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return map[string]interface{}{}, nil
+	}
+	return tomlConfigValue(m).(map[string]interface{}), nil
+}
+
+// tomlConfigValue converts a decoded TOML value to the config form
+func tomlConfigValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = tomlConfigValue(v[k])
+		}
+	case []map[string]interface{}:
+		list := make([]interface{}, len(v))
+		for i := range v {
+			list[i] = tomlConfigValue(v[i])
+		}
+		return list
+	case []interface{}:
+		for i := range v {
+			v[i] = tomlConfigValue(v[i])
+		}
+	case int64:
+		return int(v)
+	case time.Time:
+		return tomlDatetime(v)
+	}
+	return v
+}
+
+// tomlDatetime writes a time as it would be in TOML.  The toml package
+// marks local dates and times with the name of their location.
+func tomlDatetime(t time.Time) datetime {
+	frac := ""
+	if t.Nanosecond() != 0 {
+		frac = ".999999999"
+	}
+	switch t.Location().String() {
+	case "date-local":
+		return datetime(t.Format("2006-01-02"))
+	case "time-local":
+		return datetime(t.Format("15:04:05" + frac))
+	case "datetime-local":
+		return datetime(t.Format("2006-01-02T15:04:05" + frac))
+	}
+	return datetime(t.Format(time.RFC3339Nano))
+}
+
+// writeYAML writes a value in block style YAML, with keys sorted
+func writeYAML(buf *bytes.Buffer, v interface{}, indent string) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, k := range sortedKeys(v) {
+			buf.WriteString(indent + tomlKey(k) + ":")
+			writeYAMLChild(buf, v[k], indent+"  ")
+		}
+	case []interface{}:
+		for _, item := range v {
+			switch child := item.(type) {
+			case map[string]interface{}, []interface{}:
+				// the first line of the nested block goes after the -
+				nested := bytes.Buffer{}
+				writeYAMLChild(&nested, child, indent+"  ")
+				buf.WriteString(indent + "-" + strings.TrimPrefix(nested.String(), "\n"+indent+" "))
+			default:
+				buf.WriteString(indent + "- " + yamlScalar(item) + "\n")
+			}
+		}
+	}
+}
+
+// writeYAMLChild writes the value of a key or list item, on the same
+// line if it is a scalar or empty, otherwise as an indented block.
+func writeYAMLChild(buf *bytes.Buffer, v interface{}, indent string) {
+	switch child := v.(type) {
+	case map[string]interface{}:
+		if len(child) == 0 {
+			buf.WriteString(" {}\n")
+			return
+		}
+	case []interface{}:
+		if len(child) == 0 {
+			buf.WriteString(" []\n")
+			return
+		}
+	default:
+		buf.WriteString(" " + yamlScalar(child) + "\n")
+		return
+	}
+	buf.WriteByte('\n')
+	writeYAML(buf, v, indent)
+}
+
+func yamlScalar(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return strconv.Quote(v)
+	case float64:
+		return formatFloat(v)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,171 @@
+package mdtool
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	want := map[string]interface{}{
+		"fmt": map[string]interface{}{
+			"line_length": 80,
+			"list_bullet": "*",
+			"code_formatters": map[string]interface{}{
+				"sh": "shfmt -i 2",
+			},
+		},
+		"vet": map[string]interface{}{
+			"disable": []interface{}{"naked-url-parens", "orphan-page"},
+			"entry":   []interface{}{"index.md"},
+		},
+		"overrides": []interface{}{
+			map[string]interface{}{
+				"path": "docs",
+				"fmt":  map[string]interface{}{"line_length": -1},
+			},
+		},
+	}
+	yaml := `# comment
+fmt:
+  line_length: 80   # trailing comment
+  list_bullet: "*"
+  code_formatters:
+    sh: shfmt -i 2
+vet:
+  disable: [naked-url-parens, 'orphan-page']
+  entry:
+  - index.md
+overrides:
+  - path: docs
+    fmt:
+      line_length: -1
+`
+	toml := `# comment
+[fmt]
+line_length = 80
+list_bullet = "*"
+code_formatters = { sh = "shfmt -i 2" }
+
+[vet]
+disable = ["naked-url-parens", "orphan-page"]
+entry = ["index.md"]
+
+[[overrides]]
+path = "docs"
+fmt.line_length = -1
+`
+	got, err := parseYAML([]byte(yaml))
+	if err != nil {
+		t.Fatalf("YAML: %s", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("YAML:\n%v\n%v", want, got)
+	}
+	got, err = parseTOML([]byte(toml))
+	if err != nil {
+		t.Fatalf("TOML: %s", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TOML:\n%v\n%v", want, got)
+	}
+
+	for _, bad := range []string{"fmt:\n  a: 1\n b: 2\n", "- a\n", "fmt\n"} {
+		if _, err := parseYAML([]byte(bad)); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	root, err := ioutil.TempDir("", "mdtools")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	files := map[string]string{
+		".editorconfig": "root = true\ncharset = utf-8\n[*.md]\nmax_line_length = 100\nend_of_line = crlf\n",
+		".mdtools.yaml": `fmt:
+  line_length: 80
+exclude: [vendor]
+overrides:
+  - path: docs/api
+    fmt:
+      list_bullet: "*"
+`,
+		"docs/.mdtools.toml":     "[fmt]\nhr_length = 5\n",
+		"other/.mdtools.yaml":    "root: true\nvet:\n  disable: [orphan-page]\n",
+		"bad/.mdtools.yaml":      "fmt:\n  line_lenght: 80\n",
+		"docs/api/x.md":          "",
+		"docs/y.md":              "",
+		"other/z.md":             "",
+		"vendor/lib/README.md":   "",
+		"bad/w.md":               "",
+		"docs/api/.editorconfig": "[*]\nmax_line_length = off\nend_of_line = lf\n",
+	}
+	for name, content := range files {
+		name = filepath.Join(root, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(name), 0755)
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		name     string
+		length   int
+		bullet   string
+		hrLength int
+		ending   string
+		included bool
+	}{
+		{"docs/api/x.md", 80, "*", 5, "lf", true},
+		{"docs/y.md", 80, "-", 5, "crlf", true},
+		{"other/z.md", 100, "-", 3, "crlf", true},
+		{"vendor/lib/README.md", 80, "-", 3, "crlf", false},
+	}
+	for _, tt := range cases {
+		c, err := LoadConfig(filepath.Join(root, tt.name))
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		opt := c.FmtOptions()
+		if opt.LineLength != tt.length || opt.ListBulletChar != tt.bullet || opt.HrLength != tt.hrLength {
+			t.Errorf("%s: got line length %d, bullet %q, hr length %d", tt.name, opt.LineLength, opt.ListBulletChar, opt.HrLength)
+		}
+		if opt.LineEnding != tt.ending {
+			t.Errorf("%s: got line ending %q", tt.name, opt.LineEnding)
+		}
+		if got := c.Included(filepath.Join(root, tt.name)); got != tt.included {
+			t.Errorf("%s: expected included=%v", tt.name, tt.included)
+		}
+	}
+
+	c, err := LoadConfig(filepath.Join(root, "other/z.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vopt, err := c.VetOptions()
+	if err != nil || !vopt.Disable[FaultOrphanPage] || len(c.Files) != 1 {
+		t.Errorf("other/z.md: expected only its own config, got %v %v", c.Files, err)
+	}
+
+	if _, isRoot, err := readEditorConfig(filepath.Join(root, ".editorconfig")); err != nil || !isRoot {
+		t.Errorf(".editorconfig: expected root = true, got %v %v", isRoot, err)
+	}
+
+	if _, err := LoadConfig(filepath.Join(root, "bad/w.md")); err == nil {
+		t.Errorf("expected error for unknown setting")
+	}
+}
+
+func TestDefaultListIndent(t *testing.T) {
+	opt := fmtOptions(defaultConfig()["fmt"].(map[string]interface{}))
+	src := "- a\n    - b\n        - c\n"
+	if got := string(Fmt2([]byte(src), &opt)); got != src {
+		t.Errorf("fmt2: got %q, want %q", got, src)
+	}
+}
@@ -0,0 +1,261 @@
+package mdtool
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// diffContext is the number of unchanged lines shown around a change
+const diffContext = 3
+
+// diffLine is one line of an edit script
+type diffLine struct {
+	op   byte // ' ', '-' or '+'
+	text []byte
+}
+
+// Diff returns a unified diff of a and b, or nil if they are the same.
+// The names are used for the --- and +++ header lines.
+func Diff(aName string, a []byte, bName string, b []byte) []byte {
+	if bytes.Equal(a, b) {
+		return nil
+	}
+	edits := diffLines(splitLines(a), splitLines(b))
+
+	out := bytes.Buffer{}
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", aName, bName)
+	for start := 0; start < len(edits); {
+		// find the next change
+		for start < len(edits) && edits[start].op == ' ' {
+			start++
+		}
+		if start == len(edits) {
+			break
+		}
+
+		// extend the hunk until there is enough unchanged text
+		// between this change and the next
+		end := start
+		for end < len(edits) {
+			if edits[end].op != ' ' {
+				end++
+				continue
+			}
+			same := end
+			for same < len(edits) && edits[same].op == ' ' {
+				same++
+			}
+			if same == len(edits) || same-end > 2*diffContext {
+				break
+			}
+			end = same
+		}
+		first := start - diffContext
+		if first < 0 {
+			first = 0
+		}
+		last := end + diffContext
+		if last > len(edits) {
+			last = len(edits)
+		}
+
+		// line numbers of the start of the hunk
+		aLine, bLine := 1, 1
+		for _, e := range edits[:first] {
+			if e.op != '+' {
+				aLine++
+			}
+			if e.op != '-' {
+				bLine++
+			}
+		}
+		aCount, bCount := 0, 0
+		for _, e := range edits[first:last] {
+			if e.op != '+' {
+				aCount++
+			}
+			if e.op != '-' {
+				bCount++
+			}
+		}
+		if aCount == 0 {
+			aLine--
+		}
+		if bCount == 0 {
+			bLine--
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(aLine, aCount), hunkRange(bLine, bCount))
+		for _, e := range edits[first:last] {
+			out.WriteByte(e.op)
+			out.Write(e.text)
+			if len(e.text) == 0 || e.text[len(e.text)-1] != '\n' {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		start = last
+	}
+	return out.Bytes()
+}
+
+func hunkRange(line, count int) string {
+	if count == 1 {
+		return fmt.Sprintf("%d", line)
+	}
+	return fmt.Sprintf("%d,%d", line, count)
+}
+
+// splitLines splits text into lines, keeping the newlines
+func splitLines(text []byte) [][]byte {
+	var lines [][]byte
+	for offset := 0; offset < len(text); {
+		line := nextLine(text, offset)
+		lines = append(lines, line)
+		offset += len(line)
+	}
+	return lines
+}
+
+// diffLines returns an edit script turning a into b, using Myers'
+// O(ND) algorithm in linear space: find the middle snake of a shortest
+// edit script, and then the scripts on either side of it.  In each run
+// of changes the removed lines come first.
+func diffLines(a, b [][]byte) []diffLine {
+	// compare line numbers instead of text
+	ids := make(map[string]int)
+	lineIDs := func(lines [][]byte) []int {
+		out := make([]int, len(lines))
+		for i, line := range lines {
+			id, ok := ids[string(line)]
+			if !ok {
+				id = len(ids)
+				ids[string(line)] = id
+			}
+			out[i] = id
+		}
+		return out
+	}
+	d := &differ{a: a, b: b, aIDs: lineIDs(a), bIDs: lineIDs(b)}
+	max := (len(a)+len(b)+1)/2 + 1
+	d.forward = make([]int, 2*max+1)
+	d.backward = make([]int, 2*max+1)
+	d.diff(0, len(a), 0, len(b))
+
+	// put removed lines before added ones
+	edits := d.edits
+	for start := 0; start < len(edits); {
+		if edits[start].op == ' ' {
+			start++
+			continue
+		}
+		end := start
+		for end < len(edits) && edits[end].op != ' ' {
+			end++
+		}
+		sort.SliceStable(edits[start:end], func(i, j int) bool {
+			return edits[start+i].op == '-' && edits[start+j].op == '+'
+		})
+		start = end
+	}
+	return edits
+}
+
+type differ struct {
+	a, b       [][]byte
+	aIDs, bIDs []int
+	edits      []diffLine
+
+	// the furthest x reached on each diagonal, going forward from the
+	// start and backward from the end, reused for each middle snake
+	forward, backward []int
+}
+
+// diff adds the edits turning a[aLo:aHi] into b[bLo:bHi]
+func (d *differ) diff(aLo, aHi, bLo, bHi int) {
+	// the common start and end
+	for aLo < aHi && bLo < bHi && d.aIDs[aLo] == d.bIDs[bLo] {
+		d.edits = append(d.edits, diffLine{' ', d.a[aLo]})
+		aLo++
+		bLo++
+	}
+	aEnd := aHi
+	for aLo < aHi && bLo < bHi && d.aIDs[aHi-1] == d.bIDs[bHi-1] {
+		aHi--
+		bHi--
+	}
+	switch {
+	case aLo == aHi:
+		for _, line := range d.b[bLo:bHi] {
+			d.edits = append(d.edits, diffLine{'+', line})
+		}
+	case bLo == bHi:
+		for _, line := range d.a[aLo:aHi] {
+			d.edits = append(d.edits, diffLine{'-', line})
+		}
+	default:
+		x, y, u, v := d.middleSnake(aLo, aHi, bLo, bHi)
+		d.diff(aLo, x, bLo, y)
+		for _, line := range d.a[x:u] {
+			d.edits = append(d.edits, diffLine{' ', line})
+		}
+		d.diff(u, aHi, v, bHi)
+	}
+	for _, line := range d.a[aHi:aEnd] {
+		d.edits = append(d.edits, diffLine{' ', line})
+	}
+}
+
+// middleSnake returns the start and end of the snake, a run of equal
+// lines, in the middle of a shortest edit script turning a[aLo:aHi]
+// into b[bLo:bHi].  Diagonal k is where x - y = k, x counting lines of
+// a and y lines of b, from the start going forward and from the end
+// going backward.
+func (d *differ) middleSnake(aLo, aHi, bLo, bHi int) (x, y, u, v int) {
+	n, m := aHi-aLo, bHi-bLo
+	delta := n - m
+	odd := delta%2 != 0
+	max := (n+m+1)/2 + 1
+	off := len(d.forward) / 2
+	for k := -max; k <= max; k++ {
+		d.forward[off+k], d.backward[off+k] = 0, 0
+	}
+	for D := 0; D <= max; D++ {
+		for k := -D; k <= D; k += 2 {
+			var x int
+			if k == -D || k != D && d.forward[off+k-1] < d.forward[off+k+1] {
+				x = d.forward[off+k+1]
+			} else {
+				x = d.forward[off+k-1] + 1
+			}
+			y := x - k
+			x0, y0 := x, y
+			for x < n && y < m && d.aIDs[aLo+x] == d.bIDs[bLo+y] {
+				x++
+				y++
+			}
+			d.forward[off+k] = x
+			if c := delta - k; odd && c >= -(D-1) && c <= D-1 && x+d.backward[off+c] >= n {
+				return aLo + x0, bLo + y0, aLo + x, bLo + y
+			}
+		}
+		for c := -D; c <= D; c += 2 {
+			var x int
+			if c == -D || c != D && d.backward[off+c-1] < d.backward[off+c+1] {
+				x = d.backward[off+c+1]
+			} else {
+				x = d.backward[off+c-1] + 1
+			}
+			y := x - c
+			x0, y0 := x, y
+			for x < n && y < m && d.aIDs[aHi-x-1] == d.bIDs[bHi-y-1] {
+				x++
+				y++
+			}
+			d.backward[off+c] = x
+			if k := delta - c; !odd && k >= -D && k <= D && x+d.forward[off+k] >= n {
+				return aHi - x, bHi - y, aHi - x0, bHi - y0
+			}
+		}
+	}
+	panic("diff: no middle snake")
+}
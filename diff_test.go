@@ -0,0 +1,102 @@
+package mdtool
+
+import (
+	"bytes"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want string
+	}{
+		{"a\nb\n", "a\nb\n", ""},
+		{"a\nb\nc\n", "a\nB\nc\n",
+			"--- x\n+++ y\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n"},
+		{"", "a\n", "--- x\n+++ y\n@@ -0,0 +1 @@\n+a\n"},
+		{"a", "a\n", "--- x\n+++ y\n@@ -1 +1 @@\n-a\n\\ No newline at end of file\n+a\n"},
+		{"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n", "1\n2\n3\n4\n5\n6\n7\n8\n9\nten\n",
+			"--- x\n+++ y\n@@ -7,4 +7,4 @@\n 7\n 8\n 9\n-10\n+ten\n"},
+		{"x\n1\n2\n3\n4\n5\n6\n7\n8\ny\n", "X\n1\n2\n3\n4\n5\n6\n7\n8\nY\n",
+			"--- x\n+++ y\n@@ -1,4 +1,4 @@\n-x\n+X\n 1\n 2\n 3\n@@ -7,4 +7,4 @@\n 6\n 7\n 8\n-y\n+Y\n"},
+	}
+	for idx, tt := range cases {
+		got := string(Diff("x", []byte(tt.a), "y", []byte(tt.b)))
+		if got != tt.want {
+			t.Errorf("Case %d)\n%q\n%q", idx, tt.want, got)
+		}
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	// each script turns a into b with the fewest changes, checked
+	// against the longest common subsequence
+	rnd := rand.New(rand.NewSource(1))
+	randomLines := func() [][]byte {
+		lines := make([][]byte, rnd.Intn(12))
+		for i := range lines {
+			lines[i] = []byte{byte('a' + rnd.Intn(4))}
+		}
+		return lines
+	}
+	for idx := 0; idx < 500; idx++ {
+		a, b := randomLines(), randomLines()
+		var gotA, gotB [][]byte
+		same := 0
+		for _, e := range diffLines(a, b) {
+			if e.op != '+' {
+				gotA = append(gotA, e.text)
+			}
+			if e.op != '-' {
+				gotB = append(gotB, e.text)
+			}
+			if e.op == ' ' {
+				same++
+			}
+		}
+		join := func(lines [][]byte) string { return string(bytes.Join(lines, []byte("\n"))) }
+		if join(gotA) != join(a) || join(gotB) != join(b) {
+			t.Fatalf("Case %d) %q to %q: script gives %q to %q", idx, a, b, gotA, gotB)
+		}
+		lcs := make([][]int, len(a)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(b)+1)
+		}
+		for i := len(a) - 1; i >= 0; i-- {
+			for j := len(b) - 1; j >= 0; j-- {
+				switch {
+				case bytes.Equal(a[i], b[j]):
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				case lcs[i+1][j] >= lcs[i][j+1]:
+					lcs[i][j] = lcs[i+1][j]
+				default:
+					lcs[i][j] = lcs[i][j+1]
+				}
+			}
+		}
+		if same != lcs[0][0] {
+			t.Errorf("Case %d) %q to %q: %d unchanged lines, want %d", idx, a, b, same, lcs[0][0])
+		}
+	}
+
+	// a large file with a few changes
+	var a, b [][]byte
+	for i := 0; i < 20000; i++ {
+		line := []byte(strconv.Itoa(i))
+		a = append(a, line)
+		if i%5000 != 0 {
+			b = append(b, line)
+		}
+	}
+	changes := 0
+	for _, e := range diffLines(a, b) {
+		if e.op != ' ' {
+			changes++
+		}
+	}
+	if changes != 4 {
+		t.Errorf("large file: %d changes, want 4", changes)
+	}
+}
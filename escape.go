@@ -0,0 +1,258 @@
+package mdtool
+
+import (
+	"bytes"
+	"regexp"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Text from the parsers has its backslash escapes removed, so whether
+// a character needs one again depends on where it is written.  Most
+// characters only need one next to certain others, e.g. < before a
+// letter, and are escaped by escapeText.  The rest only matter at the
+// start of a line, e.g. # or 1., which isn't known until a paragraph is
+// wrapped.  Text that could start a line is written after an
+// escapeMarker, and escapeLineStarts escapes it if it does.
+
+// escapeMarker is written before text that may need escaping if it
+// starts a line
+const escapeMarker = '\x02'
+
+// textContext is where inline text is written
+type textContext int
+
+const (
+	inText      textContext = iota
+	inLinkText              // text of a link or image, where ] ends the text
+	inTableCell             // where | ends the cell
+	inHeading               // an ATX heading, where trailing #s are dropped
+)
+
+// unknownRune stands for a neighboring character that isn't known, and
+// unknownText for text that isn't.  Characters next to them are escaped
+// if they could need it.
+const unknownRune = utf8.RuneError
+
+var unknownText = []byte{0xff}
+
+// escapeText backslash-escapes the characters of text that would be
+// read as Markdown syntax where it is written.  prev is the character
+// written before the text, 0 at the start of a block, or unknownRune.
+// next is the text written after it, as far as it is known, ending with
+// unknownText unless it reaches the end of the block.
+func escapeText(text []byte, ctx textContext, prev rune, next []byte) []byte {
+	out := bytes.Buffer{}
+	before := prev
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRune(text[i:])
+		after := rune(0)
+		if i+size < len(text) {
+			after, _ = utf8.DecodeRune(text[i+size:])
+		} else if len(next) != 0 {
+			after, _ = utf8.DecodeRune(next)
+		}
+		if needsEscape(r, ctx, before, after) ||
+			r == '[' && opensLink(text[i+size:], next) ||
+			(r == '*' || r == '_') && (before == r || before == unknownRune ||
+				!isSpaceOrEdge(before) && (after == unknownRune || isSpaceOrEdge(after)) ||
+				!isSpaceOrEdge(after) && hasCloser(byte(r), text[i+size:], next)) {
+			out.WriteByte('\\')
+		}
+		out.Write(text[i : i+size])
+		before = r
+		i += size
+	}
+	return out.Bytes()
+}
+
+// needsEscape is true if r would be read as syntax between before and
+// after
+func needsEscape(r rune, ctx textContext, before, after rune) bool {
+	switch r {
+	case '`':
+		return true
+	case '\\':
+		// a backslash only escapes punctuation, or is a hard break at
+		// the end of a line, which wrapping may put it at
+		return after == unknownRune || isSpaceOrEdge(after) || after < utf8.RuneSelf && bytes.IndexByte(escapeChars, byte(after)) != -1
+	case '~':
+		return before == '~' || after == '~' || after == unknownRune
+	case '<':
+		// an HTML tag or autolink.  Blackfriday drops the text after
+		// a < and a letter or digit up to the next > even if it isn't
+		// a tag.
+		return after == unknownRune || after == '/' || after == '!' || after == '?' || isAlnum(after)
+	case '&':
+		// an entity
+		return after == unknownRune || after == '#' || isAlnum(after)
+	case ']':
+		return ctx == inLinkText
+	case '|':
+		return ctx == inTableCell
+	case '#':
+		return ctx == inHeading && (after == 0 || after == unknownRune)
+	}
+	return false
+}
+
+// escapeChars are the characters blackfriday lets a backslash escape
+var escapeChars = []byte("\\`*_{}[]()#+-.!:|&<>~")
+
+// hasCloser is true if the text after an emphasis character c, text
+// and then next, has one that could close the emphasis.  Blackfriday
+// looks for one without skipping escaped characters, and an opening
+// character followed by space can't open emphasis, so the only way to
+// keep the text is to escape the opening one.
+func hasCloser(c byte, text, next []byte) bool {
+	rest := append(append([]byte{c}, text...), next...)
+	for j := 1; j < len(rest); j++ {
+		if rest[j] == unknownText[0] {
+			return true
+		}
+		if rest[j] != c || isSpace(rest[j-1]) {
+			continue
+		}
+		// with NoIntraEmphasis, a closing character must be followed
+		// by space or punctuation
+		if j+1 == len(rest) {
+			return true
+		}
+		if f := rest[j+1]; f == unknownText[0] || isSpace(f) || isASCIIPunct(rune(f)) {
+			return true
+		}
+	}
+	return false
+}
+
+// opensLink is true if a [ followed by text and then next would start
+// an inline link, [a](b), or a link reference definition, [a]: b.
+// Other brackets may be reference links, which are kept as text while
+// their definitions are taken out.
+func opensLink(text, next []byte) bool {
+	rest := text
+	if bytes.IndexByte(text, ']') == -1 {
+		end := bytes.IndexByte(next, unknownText[0])
+		if end != -1 && bytes.IndexByte(next[:end], ']') == -1 {
+			return true
+		}
+		rest, next = next, nil
+	}
+	i := bytes.IndexByte(rest, ']')
+	if i == -1 {
+		return false
+	}
+	after := rest[i+1:]
+	if len(after) == 0 {
+		after = next
+	}
+	return len(after) != 0 && (after[0] == '(' || after[0] == ':' || after[0] == unknownText[0])
+}
+
+var entity = regexp.MustCompile(`^&#?[[:alnum:]]+;$`)
+
+// isEntity is true if text is an HTML entity, e.g. &copy;
+func isEntity(text []byte) bool {
+	return entity.Match(text)
+}
+
+// isSpaceOrEdge is true for whitespace and the edge of a block
+func isSpaceOrEdge(r rune) bool {
+	return r == 0 || (r != unknownRune && unicode.IsSpace(r))
+}
+
+func isASCIIPunct(r rune) bool {
+	return r < utf8.RuneSelf && (unicode.IsPunct(r) || unicode.IsSymbol(r))
+}
+
+// mayStartBlock is true if text starting with c could be read as the
+// start of a block at the start of a line, see unsafeLineStart.  A
+// setext underline of = can't be escaped, but also can't be the first
+// line.
+func mayStartBlock(c byte) bool {
+	switch c {
+	case '#', '>', '<', '|', '-', '+', '*', '_', '`', '~':
+		return true
+	}
+	return c >= '0' && c <= '9'
+}
+
+// escapeLineStarts escapes marked text that starts a line, the first
+// line of text or after a hard break, if the line would be read as
+// something other than paragraph text.  All of the markers are removed.
+func escapeLineStarts(text []byte) []byte {
+	if bytes.IndexByte(text, escapeMarker) == -1 {
+		return text
+	}
+	out := bytes.Buffer{}
+	lineStart := true
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if c != escapeMarker {
+			out.WriteByte(c)
+			lineStart = c == hardBreakMarker || c == lineBreakMarker
+			continue
+		}
+		if !lineStart {
+			continue
+		}
+		// the first word, without markers
+		end := i + 1
+		for end < len(text) && !isSpace(text[end]) && text[end] != hardBreakMarker {
+			end++
+		}
+		word := bytes.Replace(text[i+1:end], []byte{escapeMarker}, nil, -1)
+		if len(word) == 0 || !unsafeLineStart(word) {
+			continue
+		}
+		if c := word[len(word)-1]; c == '.' || c == ')' {
+			if c0 := word[0]; c0 >= '0' && c0 <= '9' {
+				// an ordered list marker, escape the . or )
+				out.Write(word[:len(word)-1])
+				out.WriteByte('\\')
+				out.WriteByte(c)
+				i = end - 1
+				lineStart = false
+				continue
+			}
+		}
+		out.WriteByte('\\')
+		lineStart = false
+	}
+	return out.Bytes()
+}
+
+// stripEscapeMarkers removes the markers from text that can't start a
+// line
+func stripEscapeMarkers(text []byte) []byte {
+	return bytes.Replace(text, []byte{escapeMarker}, nil, -1)
+}
+
+// codeSpan writes code as a code span.  The fence is one backtick more
+// than the longest run of them in the code, and a code span that starts
+// or ends with a backtick has a space inside the fences, which the
+// parsers trim.
+func codeSpan(code []byte) []byte {
+	longest, run := 0, 0
+	for _, c := range code {
+		if c != '`' {
+			run = 0
+			continue
+		}
+		if run++; run > longest {
+			longest = run
+		}
+	}
+	fence := bytes.Repeat([]byte{'`'}, longest+1)
+	pad := len(code) > 0 && (code[0] == '`' || code[len(code)-1] == '`')
+	out := make([]byte, 0, len(code)+2*len(fence)+2)
+	out = append(out, fence...)
+	if pad {
+		out = append(out, ' ')
+	}
+	out = append(out, code...)
+	if pad {
+		out = append(out, ' ')
+	}
+	return append(out, fence...)
+}
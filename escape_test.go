@@ -0,0 +1,182 @@
+package mdtool
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	bf "gopkg.in/russross/blackfriday.v2"
+)
+
+func TestFmtEscaping(t *testing.T) {
+	cases := []struct {
+		src  string
+		want string
+	}{
+		{"\\# not a heading", "\\# not a heading"},
+		{"issue #42 is fixed", "issue #42 is fixed"},
+		{"# heading \\#", "# heading \\#"},
+		{"1\\. not a list", "1\\. not a list"},
+		{"10\\) not a list", "10\\) not a list"},
+		{"\\- not a list", "\\- not a list"},
+		{"\\+ not a list", "\\+ not a list"},
+		{"\\> not a quote", "\\> not a quote"},
+		{"\\`not code\\`", "\\`not code\\`"},
+		{"\\<b> not a tag", "\\<b> not a tag"},
+		{"a < b & c", "a < b & c"},
+		{"&copy; and \\&copy;", "&copy; and \\&copy;"},
+		{"snake_case", "snake_case"},
+		{"2 * 3 * 4", "2 * 3 * 4"},
+		{"\\*not emphasis\\*", "\\*not emphasis\\*"},
+		{"a \\~\\~not struck\\~\\~", "a \\~\\~not struck\\~\\~"},
+		{"tilde ~ alone", "tilde ~ alone"},
+		{"[a\\]b](/x)", "[a\\]b](/x)"},
+		{"| a \\| b | c |\n|---|---|\n| 1 | 2 |", "| a \\| b | c"},
+	}
+	for idx, tt := range cases {
+		for _, format := range []func([]byte, *FmtOptions) []byte{Fmt, Fmt2} {
+			got := strings.TrimSpace(string(format([]byte(tt.src), nil)))
+			if !strings.HasPrefix(got, tt.want) {
+				t.Errorf("Case %d)\n%q\n%q", idx, tt.want, got)
+			}
+		}
+	}
+}
+
+func TestFmt2EscapingLinks(t *testing.T) {
+	cases := []struct {
+		src  string
+		want string
+	}{
+		{"use \\[a\\](b) here", "use \\[a](b) here"},
+		{"[a][ref] is kept\n\n[ref]: /x", "[a][ref] is kept\n\n[ref]: /x"},
+		{"line  \n\\# not a heading", "line  \n\\# not a heading"},
+	}
+	for idx, tt := range cases {
+		got := strings.TrimSpace(string(Fmt2([]byte(tt.src), nil)))
+		if got != tt.want {
+			t.Errorf("Case %d)\n%q\n%q", idx, tt.want, got)
+		}
+	}
+}
+
+// fmtLossyFixtures are the fixtures that Fmt reads differently after
+// formatting: it flattens nested lists, breaks lists in block quotes,
+// and turns code in list items into code spans.
+var fmtLossyFixtures = map[string]bool{
+	"fixtures/junk1.md":    true,
+	"fixtures/junk2.md":    true,
+	"fixtures/junk3.md":    true,
+	"fixtures/junk5.md":    true,
+	"fixtures/nesting1.md": true,
+	"fixtures/nesting2.md": true,
+	"fixtures/nesting3.md": true,
+	"fixtures/test2.md":    true,
+	"fixtures/test3.md":    true,
+	"fixtures/test4.md":    true,
+}
+
+// TestFmtRoundTrip checks that formatting doesn't change how a document
+// is read, for the fixtures and for random paragraphs full of characters
+// that may need escaping.
+func TestFmtRoundTrip(t *testing.T) {
+	files, err := filepath.Glob("fixtures/*.md")
+	if err != nil {
+		t.Fatalf("Unable to get testcase files: %s", err)
+	}
+	for _, filename := range files {
+		raw, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatalf("Unable to read %q: %s", filename, err)
+		}
+		if err := Verify(raw, Fmt2(raw, nil)); err != nil {
+			t.Errorf("%s: %s", filename, err)
+		}
+		if want, html := fmtHTML(raw), fmtHTML(Fmt(raw, nil)); want != html && !fmtLossyFixtures[filename] {
+			t.Errorf("Fmt %s:\n%s\n%s", filename, want, html)
+		}
+	}
+
+	codeSpans := []string{"``x`y``\n", "`` `a ``\n", "``` a`` ```\n", "a ``b` `` c\n", "`` ` ``\n"}
+	for _, src := range codeSpans {
+		checkRoundTrip(t, []byte(src))
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		src := randomParagraph(r)
+		if hasUnwritableEmphasis(src) {
+			continue
+		}
+		checkRoundTrip(t, src)
+	}
+}
+
+// checkRoundTrip checks that Fmt and Fmt2 don't change how src is read
+func checkRoundTrip(t *testing.T, src []byte) {
+	got := Fmt(src, nil)
+	if want, html := fmtHTML(src), fmtHTML(got); want != html {
+		t.Errorf("Fmt %q became %q:\n%s\n%s", src, got, want, html)
+	}
+	got = Fmt2(src, nil)
+	if err := Verify(src, got); err != nil {
+		t.Errorf("Fmt2 %q became %q: %s", src, got, err)
+	}
+}
+
+var (
+	randomStarts = []string{"a", "b", "foo", "x_y", "\\#", "\\-", "\\+", "1\\.", "\\>", "\\*", "\\_", "\\`", "\\<", "\\[", "\\|", "\\~", "\\\\"}
+	randomTokens = []string{"a", "b", "foo", "1", "12", "#", "*", "_", "`", "[", "]", "(", ")", "<", ">", "&", "-", "+", ".", "|", "~", "!", ":", "=",
+		" ", " ", " ", " ", "\n",
+		"\\*", "\\_", "\\#", "\\[", "\\]", "\\`", "\\<", "\\&", "\\-", "\\+", "1\\.", "\\>", "\\|", "\\~", "\\\\", "\\a",
+		"&amp;", "&copy;", "<b>", "x_y", "a*b"}
+	spaceBeforeNewline = regexp.MustCompile(` +\n`)
+)
+
+// randomParagraph returns a paragraph of random text, without hard
+// breaks
+func randomParagraph(r *rand.Rand) []byte {
+	out := bytes.Buffer{}
+	out.WriteString(randomStarts[r.Intn(len(randomStarts))])
+	for n := 1 + r.Intn(12); n > 0; n-- {
+		tok := randomTokens[r.Intn(len(randomTokens))]
+		out.WriteString(tok)
+		if tok == "\n" {
+			out.WriteString(randomStarts[r.Intn(len(randomStarts))])
+		}
+	}
+	out.WriteByte('\n')
+	return spaceBeforeNewline.ReplaceAll(out.Bytes(), []byte("\n"))
+}
+
+// hasUnwritableEmphasis is true if blackfriday reads emphasis in src that
+// no Markdown can be written for.  It will close emphasis at an escaped
+// character, so the emphasized text can start or end with space or a
+// backslash, and emphasis inside a word must use *, so emphasis can't be
+// nested inside it.
+func hasUnwritableEmphasis(src []byte) bool {
+	found := false
+	doc := bf.New(bf.WithExtensions(bf.CommonExtensions)).Parse(src)
+	doc.Walk(func(node *bf.Node, entering bool) bf.WalkStatus {
+		if !entering || (node.Type != bf.Emph && node.Type != bf.Strong) {
+			return bf.GoToNext
+		}
+		if p := node.Parent; (p.Type == bf.Emph || p.Type == bf.Strong) && isIntraword(p) {
+			found = true
+		}
+		var text []byte
+		node.Walk(func(n *bf.Node, entering bool) bf.WalkStatus {
+			text = append(text, n.Literal...)
+			return bf.GoToNext
+		})
+		if len(text) == 0 || isSpace(text[0]) || isSpace(text[len(text)-1]) || text[len(text)-1] == '\\' {
+			found = true
+		}
+		return bf.GoToNext
+	})
+	return found
+}
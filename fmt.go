@@ -2,20 +2,20 @@ package mdtool
 
 import (
 	"bytes"
-	"go/format"
-	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/mattn/go-runewidth"
 	"github.com/russross/blackfriday"
 )
 
 type markdownRenderer struct {
-	normalTextMarker   map[*bytes.Buffer]int
-	orderedListCounter map[int]int
-	paragraph          map[int]bool // Used to keep track of whether a given list item uses a paragraph for large spacing.
-	listDepth          int
-	lastNormalText     string
+	normalTextMarker map[*bytes.Buffer]int
+	orderedLists     [][]string           // item numbers as written, see scanOrderedLists
+	listNumbers      map[int]*listNumbers // ordered list at each depth
+	paragraph        map[int]bool         // Used to keep track of whether a given list item uses a paragraph for large spacing.
+	listDepth        int
+	inHeading        bool // rendering the text of a heading
 
 	// TODO: Clean these up.
 	headers      []string
@@ -24,61 +24,30 @@ type markdownRenderer struct {
 	cells        []string
 
 	lineLength      int
+	wrap            WrapOptions
 	listBulletChar  string
 	listBulletSpace string
 	listIndent      string
 	headingStyle    string
+	listNumbering   string
+	emphasisChar    string
+	strongChar      string
 	hrText          string
 	opt             FmtOptions
+	code            CodeFormatters
+	codeStyle       *codeStyle
+	autoLinks       *autoLinks
 
 	// stringWidth is used internally to calculate visual width of a string.
 	stringWidth func(s string) (width int)
 }
 
-func formatCode(lang string, text []byte) (formattedCode []byte, ok bool) {
-	switch lang {
-	case "Go", "go":
-		gofmt, err := format.Source(text)
-		if err != nil {
-			return nil, false
-		}
-		return gofmt, true
-	default:
-		return nil, false
-	}
-}
-
 // Block-level callbacks.
 func (mr *markdownRenderer) BlockCode(out *bytes.Buffer, text []byte, lang string) {
 	doubleSpace(out)
-
-	// Parse out the language name.
-	count := 0
-	for _, elt := range strings.Fields(lang) {
-		if elt[0] == '.' {
-			elt = elt[1:]
-		}
-		if len(elt) == 0 {
-			continue
-		}
-		out.WriteString("```")
-		out.WriteString(elt)
-		count++
-		break
-	}
-
-	if count == 0 {
-		out.WriteString("```")
-	}
-	out.WriteString("\n")
-
-	if formattedCode, ok := formatCode(lang, text); ok {
-		out.Write(formattedCode)
-	} else {
-		out.Write(text)
-	}
-
-	out.WriteString("```\n")
+	info := mr.codeStyle.Info(lang)
+	formattedCode, _ := mr.code.Format(info, text)
+	mr.codeStyle.Write(out, info, formattedCode)
 }
 func (mr *markdownRenderer) BlockQuote(out *bytes.Buffer, text []byte) {
 	doubleSpace(out)
@@ -118,10 +87,16 @@ func (mr *markdownRenderer) Header(out *bytes.Buffer, text func() bool, level in
 	}
 
 	textMarker := out.Len()
-	if !text() {
+	mr.inHeading = true
+	ok := text()
+	mr.inHeading = false
+	if !ok {
 		out.Truncate(marker)
 		return
 	}
+	heading := escapeLineStarts(out.Bytes()[textMarker:])
+	out.Truncate(textMarker)
+	out.Write(heading)
 
 	if mr.headingStyle != "atx" {
 		switch level {
@@ -151,7 +126,11 @@ func (mr *markdownRenderer) List(out *bytes.Buffer, text func() bool, flags int)
 	mr.listDepth++
 	defer func() { mr.listDepth-- }()
 	if flags&blackfriday.LIST_TYPE_ORDERED != 0 {
-		mr.orderedListCounter[mr.listDepth] = 1
+		var written []string
+		if len(mr.orderedLists) != 0 {
+			written, mr.orderedLists = mr.orderedLists[0], mr.orderedLists[1:]
+		}
+		mr.listNumbers[mr.listDepth] = &listNumbers{mode: mr.listNumbering, written: written, count: len(written)}
 	}
 	// this is the entire list, e.g. <ul> to </ul>
 	if !text() {
@@ -161,19 +140,19 @@ func (mr *markdownRenderer) List(out *bytes.Buffer, text func() bool, flags int)
 }
 
 func (mr *markdownRenderer) ListItem(out *bytes.Buffer, text []byte, flags int) {
-
+	text = escapeLineStarts(text)
 	if flags&blackfriday.LIST_TYPE_ORDERED != 0 {
 		spaces := strings.Repeat(mr.listIndent, mr.listDepth-1)
-		digits := strconv.Itoa(mr.orderedListCounter[mr.listDepth])
+		numbers := mr.listNumbers[mr.listDepth]
+		digits := numbers.Next()
 		prefix := spaces + digits + "." + mr.listBulletSpace
-		indent := spaces + strings.Repeat(" ", len(digits)) + " " + mr.listBulletSpace
-		out.Write(WordWrap(text, mr.lineLength, prefix, indent))
-		mr.orderedListCounter[mr.listDepth]++
+		indent := spaces + strings.Repeat(" ", numbers.Width()) + " " + mr.listBulletSpace
+		out.Write(Wrap(text, prefix, indent, mr.wrap))
 	} else {
 		spaces := strings.Repeat(mr.listIndent, mr.listDepth-1)
 		prefix := spaces + mr.listBulletChar + mr.listBulletSpace
 		indent := spaces + " " + mr.listBulletSpace
-		out.Write(WordWrap(text, mr.lineLength, prefix, indent))
+		out.Write(Wrap(text, prefix, indent, mr.wrap))
 	}
 	out.WriteByte('\n')
 	if mr.paragraph[mr.listDepth] {
@@ -203,7 +182,7 @@ func (mr *markdownRenderer) Paragraph(out *bytes.Buffer, text func() bool) {
 
 	out.Truncate(marker)
 	out.WriteByte('\n') // <<- without copy, this overwrites tmp
-	out.Write(WordWrap(tmp, mr.lineLength, "", ""))
+	out.Write(Wrap(escapeLineStarts(bytes.TrimLeft(tmp, "\n")), "", "", mr.wrap))
 	out.WriteByte('\n')
 }
 
@@ -282,6 +261,7 @@ func (mr *markdownRenderer) TableRow(out *bytes.Buffer, text []byte) {
 
 // TableHeaderCell renders a <th> tag in markdown
 func (mr *markdownRenderer) TableHeaderCell(out *bytes.Buffer, text []byte, align int) {
+	text = stripEscapeMarkers(text)
 	mr.columnAligns = append(mr.columnAligns, align)
 	columnWidth := mr.stringWidth(string(text))
 	mr.columnWidths = append(mr.columnWidths, columnWidth)
@@ -290,6 +270,7 @@ func (mr *markdownRenderer) TableHeaderCell(out *bytes.Buffer, text []byte, alig
 
 // TableCell renders a <td> tag in markdown
 func (mr *markdownRenderer) TableCell(out *bytes.Buffer, text []byte, align int) {
+	text = stripEscapeMarkers(text)
 	columnWidth := mr.stringWidth(string(text))
 	column := len(mr.cells) % len(mr.headers)
 	if columnWidth > mr.columnWidths[column] {
@@ -298,42 +279,54 @@ func (mr *markdownRenderer) TableCell(out *bytes.Buffer, text []byte, align int)
 	mr.cells = append(mr.cells, string(text))
 }
 
-// ??
+// Footnotes is the list of footnote definitions at the end of the document.
+// Fmt cuts definitions out before parsing, so this is only used if
+// blackfriday.EXTENSION_FOOTNOTES is enabled.
 func (mr *markdownRenderer) Footnotes(out *bytes.Buffer, text func() bool) {
-	out.WriteString("<Footnotes: Not implemented.>") // TODO
+	marker := out.Len()
+	doubleSpace(out)
+	if !text() {
+		out.Truncate(marker)
+	}
 }
 
-// ??
+// FootnoteItem is a single footnote definition
 func (mr *markdownRenderer) FootnoteItem(out *bytes.Buffer, name, text []byte, flags int) {
-	out.WriteString("<FootnoteItem: Not implemented.>") // TODO
+	doubleSpace(out)
+	out.WriteString("[^")
+	out.Write(name)
+	out.WriteString("]: ")
+	out.Write(writeIndent(bytes.TrimSpace(text), indent1)[len(indent1):])
+	out.WriteByte('\n')
 }
 
 // AutoLink handles raw or naked URLs in text.
 func (mr *markdownRenderer) AutoLink(out *bytes.Buffer, link []byte, kind int) {
-	text := escape(link)
-	out.Write(text)
+	mr.autoLinks.write(out, link, mr.autoLinks.nextAutoLink(link))
 }
 
 // CodeSpan is an inline code span
 func (mr *markdownRenderer) CodeSpan(out *bytes.Buffer, text []byte) {
-	out.WriteByte('`')
-	out.Write(text)
-	out.WriteByte('`')
+	out.Write(codeSpan(text))
 }
 
 func (mr *markdownRenderer) DoubleEmphasis(out *bytes.Buffer, text []byte) {
-	out.WriteString("**")
+	intraword := endsWord(out)
+	marker := nestedEmphasisMarker(emphasisMarker(mr.strongChar, 2, intraword), text, intraword)
+	out.WriteString(marker)
 	out.Write(text)
-	out.WriteString("**")
+	out.WriteString(marker)
 }
 
 func (mr *markdownRenderer) Emphasis(out *bytes.Buffer, text []byte) {
 	if len(text) == 0 {
 		return
 	}
-	out.WriteByte('*')
+	intraword := endsWord(out)
+	marker := nestedEmphasisMarker(emphasisMarker(mr.emphasisChar, 1, intraword), text, intraword)
+	out.WriteString(marker)
 	out.Write(text)
-	out.WriteByte('*')
+	out.WriteString(marker)
 }
 
 // Image renders a span image element
@@ -375,9 +368,12 @@ func (mr *markdownRenderer) RawHtmlTag(out *bytes.Buffer, tag []byte) {
 }
 
 func (mr *markdownRenderer) TripleEmphasis(out *bytes.Buffer, text []byte) {
-	out.WriteString("***")
+	intraword := endsWord(out)
+	strong := emphasisMarker(mr.strongChar, 2, intraword)
+	emph := emphasisMarker(mr.emphasisChar, 1, intraword)
+	out.WriteString(strong + emph)
 	out.Write(text)
-	out.WriteString("***")
+	out.WriteString(emph + strong)
 }
 
 func (mr *markdownRenderer) StrikeThrough(out *bytes.Buffer, text []byte) {
@@ -386,8 +382,11 @@ func (mr *markdownRenderer) StrikeThrough(out *bytes.Buffer, text []byte) {
 	out.WriteString("~~")
 }
 
+// FootnoteRef is a reference to a footnote, e.g. [^1]
 func (mr *markdownRenderer) FootnoteRef(out *bytes.Buffer, ref []byte, id int) {
-	out.WriteString("<FootnoteRef: Not implemented.>") // TODO
+	out.WriteString("[^")
+	out.Write(ref)
+	out.WriteByte(']')
 }
 
 // escape replaces instances of backslash with escaped backslash in text.
@@ -395,54 +394,16 @@ func escape(text []byte) []byte {
 	return bytes.Replace(text, []byte(`\`), []byte(`\\`), -1)
 }
 
-func isNumber(data []byte) bool {
-	for _, b := range data {
-		if b < '0' || b > '9' {
-			return false
-		}
-	}
-	return true
-}
-
-func needsEscaping(text []byte, lastNormalText string) bool {
-	switch string(text) {
-	case `\`,
-		"`",
-		"*",
-		"_",
-		"{", "}",
-		"[", "]",
-		"(", ")",
-		"#",
-		"+",
-		"-":
-		return true
-	case "!":
-		return false
-	case ".":
-		// Return true if number, because a period after a number must be escaped to not get parsed as an ordered list.
-		return isNumber([]byte(lastNormalText))
-	case "<", ">":
-		return true
-	default:
-		return false
-	}
-}
-
 // Low-level callbacks.
 func (mr *markdownRenderer) Entity(out *bytes.Buffer, entity []byte) {
 	out.Write(entity)
 }
 
 func (mr *markdownRenderer) NormalText(out *bytes.Buffer, text []byte) {
-	normalText := string(text)
-	if needsEscaping(text, mr.lastNormalText) {
-		text = append([]byte("\\"), text...)
-	}
-	mr.lastNormalText = normalText
 	if mr.listDepth > 0 && string(text) == "\n" { // TODO: See if this can be cleaned up... It's needed for lists.
 		return
 	}
+	text = mr.escapeText(out, text)
 	cleanString := cleanWithoutTrim(string(text))
 	if cleanString == "" {
 		return
@@ -468,6 +429,38 @@ func (mr *markdownRenderer) NormalText(out *bytes.Buffer, text []byte) {
 	}
 }
 
+// escapeText escapes text where it is written, see escapeText.  The
+// text after it usually isn't known.  Neither is whether it is in a link
+// or table cell, but ] or | only come on their own from an escape.
+func (mr *markdownRenderer) escapeText(out *bytes.Buffer, text []byte) []byte {
+	prev := unknownRune
+	if out.Len() != 0 {
+		prev, _ = utf8.DecodeLastRune(out.Bytes())
+	}
+	ctx := inText
+	switch {
+	case mr.inHeading:
+		ctx = inHeading
+	case string(text) == "]":
+		ctx = inLinkText
+	case string(text) == "|":
+		ctx = inTableCell
+	}
+	next := unknownText
+	if len(text) > 1 && text[0] == '_' || bytes.HasPrefix(text, []byte("[^")) {
+		// blackfriday found no closing _ for the one starting the
+		// text, e.g. in snake_case, or no link for a footnote
+		// reference, and the rest of it has no other syntax.  Emphasis
+		// around it uses *, see nestedEmphasisMarker.
+		next = nil
+	}
+	escaped := escapeText(text, ctx, prev, next)
+	if len(text) != 0 && mayStartBlock(text[0]) {
+		escaped = append([]byte{escapeMarker}, escaped...)
+	}
+	return escaped
+}
+
 // Header and footer.
 func (mr *markdownRenderer) DocumentHeader(out *bytes.Buffer) {}
 func (mr *markdownRenderer) DocumentFooter(out *bytes.Buffer) {}
@@ -535,18 +528,29 @@ func NewRenderer(opt *FmtOptions) blackfriday.Renderer {
 		opt.HrChar = "-"
 	}
 
+	listIndent := opt.ListIndent
+	if listIndent == "" {
+		listIndent = "  "
+	}
+
 	return &markdownRenderer{
-		normalTextMarker:   make(map[*bytes.Buffer]int),
-		orderedListCounter: make(map[int]int),
-		paragraph:          make(map[int]bool),
+		normalTextMarker: make(map[*bytes.Buffer]int),
+		listNumbers:      make(map[int]*listNumbers),
+		paragraph:        make(map[int]bool),
 
 		stringWidth:     runewidth.StringWidth,
 		lineLength:      opt.LineLength,
+		wrap:            WrapOptions{Length: opt.LineLength, Mode: opt.WrapMode, TabWidth: opt.TabWidth},
 		hrText:          strings.Repeat(opt.HrChar, opt.HrLength),
 		listBulletChar:  opt.ListBulletChar,
-		listIndent:      opt.ListIndent,
+		listIndent:      listIndent,
 		listBulletSpace: opt.ListBulletSpace,
 		headingStyle:    opt.HeadingStyle,
+		listNumbering:   opt.ListNumbering,
+		emphasisChar:    opt.EmphasisChar,
+		strongChar:      opt.StrongChar,
+		code:            codeFormatters(opt, Fmt),
+		codeStyle:       newCodeStyle(opt),
 	}
 }
 
@@ -555,7 +559,18 @@ type FmtOptions struct {
 	// LineLength wraps lines at N characters, or -1 for run-on
 	LineLength int
 
-	// ListIndent is the identation string to use
+	// WrapMode is how paragraphs are wrapped: "fill" (the default) to
+	// fill each line up to LineLength, "sentence" to start each
+	// sentence on a new line, or "clause" to also break after ; and :
+	WrapMode string
+
+	// TabWidth is the width of a tab when measuring indentation for
+	// wrapping, default 4
+	TabWidth int
+
+	// ListIndent is the identation string to use for nested lists, 2
+	// spaces for Fmt and 4 for Fmt2 if empty.  Blackfriday v2 doesn't
+	// see a list nested deeper than one level with less than 4.
 	ListIndent string
 
 	// ListBulletChar is the bullet for unordered lists, either '-' or '*'
@@ -564,30 +579,124 @@ type FmtOptions struct {
 	// ListBulletSpace is whitespace between bullet and text
 	ListBulletSpace string
 
-	// HeadingStyle controls the markdown style for headlines
-	//  so far it's only hash, or nothash
+	// ListNumbering is how ordered lists are numbered: "sequential"
+	// (the default) counts up from the list's first number, "one" uses
+	// the first number for every item, which keeps diffs small when
+	// items are added, "preserve" keeps the numbers as written, and
+	// "padded" counts up with leading zeros so the numbers line up.
+	ListNumbering string
+
+	// HeadingStyle controls the markdown style for headlines,
+	// either "atx" (# h1) or "setext" (h1 underlined with ===)
 	HeadingStyle string
 
+	// EmphasisChar is the emphasis marker, "*" (the default) or "_".
+	// Emphasis inside a word always uses "*".
+	EmphasisChar string
+
+	// StrongChar is the strong emphasis marker, written twice, "*"
+	// (the default) or "_"
+	StrongChar string
+
 	// HrChar is the character to use for horizontal rules
 	HrChar string
 
 	// HrLength is the length of the horizontal rule in chars
 	HrLength int
-}
 
-// Fmt formats Markdown.
+	// TableCompact writes tables without padding the cells to line up
+	// the columns, which keeps diffs small.  Only used by Fmt2.
+	TableCompact bool
+
+	// HardBreak is how to write a hard line break, either "spaces" for
+	// two trailing spaces (the default) or "backslash".  Only used by Fmt2.
+	HardBreak string
+
+	// FootnoteRenumber renames footnotes 1, 2, 3... in order of first
+	// reference, and moves all definitions to the end of the document.
+	FootnoteRenumber bool
+
+	// LinkStyle is "reference" to convert inline links to reference
+	// links, numbered 1, 2, 3...  Otherwise links are kept as written.
+	// Only used by Fmt2.
+	LinkStyle string
+
+	// LinkRefPlacement is where the definitions for converted links go,
+	// either "document" for the end of the document (the default) or
+	// "section" for the end of each top level section.
+	LinkRefPlacement string
+
+	// AutoLinks is "keep" (the default) to write autolinks and bare URLs
+	// as they were written, or "angle" to make bare URLs <url> autolinks,
+	// which link under strict CommonMark too.
+	AutoLinks string
+
+	// FrontMatterFormat is what to do with front matter: "keep" it as
+	// written (the default), "normalize" it with keys sorted and
+	// strings quoted, or convert it to "yaml", "toml" or "json".
+	FrontMatterFormat string
+
+	// LineEnding is "lf" or "crlf" to write lines ending with \n or
+	// \r\n.  Otherwise the line ending of the input is kept, the most
+	// common one if they are mixed.  A UTF-8 byte order mark is kept.
+	LineEnding string
+
+	// CodeFenceChar is the fence for code blocks, "`" (the default) or
+	// "~".  Fences are made longer than any run of the character in the
+	// code, and ~ is used if the info string has a backtick.
+	CodeFenceChar string
+
+	// IndentedCode is "fenced" (the default) to convert indented code
+	// blocks to fenced ones, or "keep" to leave them indented.  Fmt
+	// always converts them.
+	IndentedCode string
+
+	// CodeAliases maps language names in code block info strings to the
+	// name to use, e.g. "golang" to "go".  They are added to the
+	// built-in aliases, see defaultCodeAliases.
+	CodeAliases map[string]string
+
+	// CodeFormatters are added to the built-in formatters for fenced
+	// code blocks, replacing any for the same language.
+	CodeFormatters CodeFormatters
+}
+
+// fmtExtensions are the extensions Fmt parses with, for GitHub Flavored
+// Markdown-like parsing.
+const fmtExtensions = blackfriday.EXTENSION_NO_INTRA_EMPHASIS |
+	blackfriday.EXTENSION_TABLES |
+	blackfriday.EXTENSION_FENCED_CODE |
+	blackfriday.EXTENSION_AUTOLINK |
+	blackfriday.EXTENSION_STRIKETHROUGH |
+	blackfriday.EXTENSION_SPACE_HEADERS |
+	blackfriday.EXTENSION_NO_EMPTY_LINE_BEFORE_BLOCK |
+	blackfriday.EXTENSION_DEFINITION_LISTS
+
+// Fmt formats Markdown.  A document with a control character used as
+// a marker, see hasMarkers, is returned as it is.
 func Fmt(text []byte, opt *FmtOptions) []byte {
-
-	// extensions for GitHub Flavored Markdown-like parsing.
-	const extensions = blackfriday.EXTENSION_NO_INTRA_EMPHASIS |
-		blackfriday.EXTENSION_TABLES |
-		blackfriday.EXTENSION_FENCED_CODE |
-		blackfriday.EXTENSION_AUTOLINK |
-		blackfriday.EXTENSION_STRIKETHROUGH |
-		blackfriday.EXTENSION_SPACE_HEADERS |
-		blackfriday.EXTENSION_NO_EMPTY_LINE_BEFORE_BLOCK |
-		blackfriday.EXTENSION_DEFINITION_LISTS
-
-	output := blackfriday.Markdown(text, NewRenderer(opt), extensions)
-	return output
+	if hasMarkers(text) {
+		return text
+	}
+	enc, text := splitEncoding(text)
+	fm, body := SplitFrontMatter(text)
+	output := fmtBody(body, opt)
+	return enc.join(joinFrontMatter(fm, body, output, opt), lineEnding(opt))
+}
+
+// fmtBody formats Markdown without any front matter, byte order mark
+// or \r\n line endings
+func fmtBody(text []byte, opt *FmtOptions) []byte {
+	renumber := opt != nil && opt.FootnoteRenumber
+	text, notes := extractFootnotes(text, renumber)
+	text, infos := extractCodeInfo(text)
+	r := NewRenderer(opt).(*markdownRenderer)
+	r.orderedLists = scanOrderedLists(text)
+	r.codeStyle.infos = infos
+	r.autoLinks = scanAutoLinks(text, opt)
+	output := stripEscapeMarkers(blackfriday.Markdown(text, r, fmtExtensions))
+	output = restoreCodeInfo(output, infos)
+	return restoreFootnotes(output, notes, renumber, func(note []byte) []byte {
+		return fmtBody(note, noteOptions(opt))
+	})
 }
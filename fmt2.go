@@ -5,9 +5,10 @@ import (
 	"io"
 	"log"
 	"os"
-	"strconv"
 	"strings"
+	"unicode/utf8"
 
+	"github.com/mattn/go-runewidth"
 	bf "gopkg.in/russross/blackfriday.v2"
 )
 
@@ -15,7 +16,8 @@ const indent1 = "    "
 
 type state struct {
 	buf    *bytes.Buffer
-	indent string
+	indent string // what the containers put before each line
+	first  int    // length of the first block of a list item, -1 if not known
 }
 
 type stack []state
@@ -27,7 +29,7 @@ func (s *stack) Push(v *bytes.Buffer, prefix string) {
 		indent = (*s)[len(*s)-1].indent
 	}
 	indent += prefix
-	*s = append(*s, state{buf: v, indent: indent})
+	*s = append(*s, state{buf: v, indent: indent, first: -1})
 }
 
 // get current item
@@ -39,6 +41,24 @@ func (s *stack) CurrentIndent() string {
 	return (*s)[len(*s)-1].indent
 }
 
+// Continue ends the first block of the current list item.  The lines
+// after it get prefix instead.
+func (s *stack) Continue(prefix string) {
+	top := &(*s)[len(*s)-1]
+	top.first = top.buf.Len()
+	top.indent = (*s)[len(*s)-2].indent + prefix
+}
+
+// FirstBlock returns the length of the first block of the current list
+// item, or the whole length if it has one block
+func (s *stack) FirstBlock() int {
+	top := (*s)[len(*s)-1]
+	if top.first == -1 {
+		return top.buf.Len()
+	}
+	return top.first
+}
+
 // remove last item
 func (s *stack) Pop() (*bytes.Buffer, string) {
 	res := (*s)[len(*s)-1]
@@ -52,31 +72,294 @@ func isPrevBlock(n *bf.Node) bool {
 		return false
 	}
 	switch prev.Type {
-	case bf.Paragraph, bf.BlockQuote, bf.CodeBlock, bf.List, bf.Heading:
+	case bf.Paragraph, bf.BlockQuote, bf.CodeBlock, bf.List, bf.Heading, bf.HorizontalRule, bf.Table, bf.HTMLBlock:
 		return true
 	}
 	return false
 }
 
+// prefixLines puts first before the first line of text and rest before
+// the others, the way a container block such as a block quote or list
+// item marks its lines.  Prefixes of containers nested in it are
+// already in the text, so they compose.  A blank line only gets rest
+// up to any trailing space.
+func prefixLines(text []byte, first, rest string) []byte {
+	out := bytes.Buffer{}
+	blank := strings.TrimRight(rest, " ")
+	for i, line := range bytes.Split(text, []byte{'\n'}) {
+		switch {
+		case i == 0:
+			out.WriteString(first)
+		case len(line) == 0:
+			out.WriteByte('\n')
+			out.WriteString(blank)
+		default:
+			out.WriteByte('\n')
+			out.WriteString(rest)
+		}
+		out.Write(line)
+	}
+	return out.Bytes()
+}
+
 type fmtRenderer struct {
-	debug     *log.Logger
-	olCount   map[*bf.Node]int
-	bufs      stack
-	listDepth int
+	debug        *log.Logger
+	listNumbers  map[*bf.Node]*listNumbers
+	orderedLists [][]string // item numbers as written, see scanOrderedLists
+	bufs         stack
 
-	linelen   int
-	linkTight bool
+	table *table
+
+	wrapping        WrapOptions
+	hardBreak       string
+	linkTight       bool
+	tableCompact    bool
+	listBulletChar  string
+	listBulletSpace string
+	listIndent      string
+	headingStyle    string
+	listNumbering   string
+	emphasisChar    string
+	strongChar      string
+	hrText          string
+
+	// links converted to reference style, nil to keep links inline
+	linkRefs       *linkRefs
+	linkRefSection bool
+
+	code         CodeFormatters
+	codeStyle    *codeStyle
+	indentedCode string
+	autoLinks    *autoLinks
 }
 
-func newFmtRenderer() *fmtRenderer {
+// newFmtRenderer returns a renderer for Fmt2.
+// If opt is nil the defaults are used.
+func newFmtRenderer(opt *FmtOptions) *fmtRenderer {
+	if opt == nil {
+		opt = &FmtOptions{
+			LineLength:      70,
+			HrChar:          "-",
+			HrLength:        3,
+			ListBulletChar:  "-",
+			ListIndent:      indent1,
+			ListBulletSpace: " ",
+			HeadingStyle:    "atx",
+		}
+	}
+	bulletChar := opt.ListBulletChar
+	switch bulletChar {
+	case "-", "+", "*":
+		break
+	default:
+		bulletChar = "-"
+	}
+	hrChar := opt.HrChar
+	switch hrChar {
+	case "-", "*", "_":
+		break
+	default:
+		hrChar = "-"
+	}
+	hrLength := opt.HrLength
+	if hrLength < 3 {
+		hrLength = 3
+	}
+	listIndent := opt.ListIndent
+	if listIndent == "" {
+		listIndent = indent1
+	}
+	bulletSpace := opt.ListBulletSpace
+	if bulletSpace == "" {
+		bulletSpace = " "
+	}
+	hardBreak := "  "
+	if opt.HardBreak == "backslash" {
+		hardBreak = "\\"
+	}
+	headingStyle := opt.HeadingStyle
+	if headingStyle == "" {
+		headingStyle = "atx"
+	}
 	return &fmtRenderer{
-		debug:     log.New(os.Stderr, "debug ", 0),
-		olCount:   make(map[*bf.Node]int),
-		bufs:      make(stack, 0, 16),
-		linelen:   70,
-		linkTight: true,
+		debug:           log.New(os.Stderr, "debug ", 0),
+		listNumbers:     make(map[*bf.Node]*listNumbers),
+		bufs:            make(stack, 0, 16),
+		wrapping:        WrapOptions{Length: opt.LineLength, Mode: opt.WrapMode, TabWidth: opt.TabWidth},
+		hardBreak:       hardBreak,
+		linkTight:       true,
+		listBulletChar:  bulletChar,
+		listBulletSpace: bulletSpace,
+		listIndent:      listIndent,
+		headingStyle:    headingStyle,
+		listNumbering:   opt.ListNumbering,
+		emphasisChar:    opt.EmphasisChar,
+		strongChar:      opt.StrongChar,
+		hrText:          strings.Repeat(hrChar, hrLength),
+		tableCompact:    opt.TableCompact,
+		linkRefSection:  opt.LinkRefPlacement == "section",
+		code:            codeFormatters(opt, Fmt2),
+		codeStyle:       newCodeStyle(opt),
+		indentedCode:    opt.IndentedCode,
+	}
+}
+
+// hardBreakMarker stands in for a hard line break in paragraph text
+// until the paragraph is wrapped.  lineBreakMarker is a line break that
+// is kept, without the hard break syntax.
+const (
+	hardBreakMarker = '\x00'
+	lineBreakMarker = '\x01'
+)
+
+// wrap word wraps the text of a paragraph, keeping any hard line breaks.
+// A paragraph that starts with raw HTML, e.g. <details>, is left as-is.
+// The lines are shorter by what the containers put before them,
+// including the bullet on the first line of a list item.
+func (f *fmtRenderer) wrap(node *bf.Node, text []byte) []byte {
+	if isHTMLParagraph(node) {
+		text = bytes.Replace(text, []byte{hardBreakMarker}, []byte(f.hardBreak+"\n"), -1)
+		text = bytes.Replace(text, []byte{lineBreakMarker}, nil, -1)
+		return bytes.TrimRight(text, "\n")
+	}
+	opt := f.wrapping
+	if opt.Length > 1 {
+		tabWidth := opt.TabWidth
+		if tabWidth <= 0 {
+			tabWidth = 4
+		}
+		opt.Length -= textWidth(f.bufs.CurrentIndent()+f.blockPad(node), 0, tabWidth)
+		if opt.Length < 2 {
+			opt.Length = 2
+		}
+	}
+	out := bytes.Buffer{}
+	for {
+		i := bytes.IndexAny(text, string([]byte{hardBreakMarker, lineBreakMarker}))
+		if i == -1 {
+			out.Write(Wrap(text, "", "", opt))
+			return out.Bytes()
+		}
+		out.Write(Wrap(text[:i], "", "", opt))
+		if text[i] == hardBreakMarker {
+			out.WriteString(f.hardBreak)
+		}
+		out.WriteByte('\n')
+		text = text[i+1:]
+	}
+}
+
+// writeSeparator writes what goes between a block and the one before
+// it: a blank line, or a line break between the blocks of a tight list.
+// Items of a loose list only have a blank line after an item with more
+// than one block, unless no item has more than one.  A list that isn't
+// in a list item is ended with two blank lines.
+func (f *fmtRenderer) writeSeparator(out *bytes.Buffer, node *bf.Node) {
+	switch {
+	case node.Type == bf.Item:
+		if node.Prev == nil {
+			return
+		}
+		out.WriteByte('\n')
+		if !node.Parent.Tight && (countChildren(node.Prev) > 1 || !hasBlockItems(node.Parent)) {
+			out.WriteByte('\n')
+		}
+	case !isPrevBlock(node):
+		return
+	case node.Parent.Type == bf.Item && node.Parent.Parent.Tight:
+		out.WriteByte('\n')
+	case node.Prev.Type == bf.List && node.Parent.Type != bf.Item:
+		out.WriteString("\n\n\n")
+	default:
+		out.WriteString("\n\n")
+	}
+}
+
+// hasBlockItems is true if an item of the list has more than one block
+func hasBlockItems(list *bf.Node) bool {
+	for item := list.FirstChild; item != nil; item = item.Next {
+		if countChildren(item) > 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// itemIndent is the indent of the blocks of a list item after its
+// first block
+func (f *fmtRenderer) itemIndent(item *bf.Node) string {
+	indent := f.listIndent
+	if width := f.bulletWidth(item); len(indent) < width {
+		indent = strings.Repeat(" ", width)
+	}
+	if !item.Parent.Tight && len(indent) < len(indent1) {
+		// blackfriday ends the list at a block after a blank line
+		// unless it is indented 4 spaces
+		indent = indent1
+	}
+	return indent
+}
+
+// blockPad is the extra indent of a paragraph or block quote after the
+// first block of a loose list item, so it starts four spaces past the
+// item's text, but short of the 8 spaces that would make it code.
+// Other blocks only get the item indent, since blackfriday would keep
+// any more in a code block's lines.
+func (f *fmtRenderer) blockPad(node *bf.Node) string {
+	item := node.Parent
+	if item == nil || item.Type != bf.Item || node.Prev == nil || item.Parent.Tight {
+		return ""
+	}
+	indent := f.bulletWidth(item) + len(indent1)
+	if indent > 2*len(indent1)-1 {
+		indent = 2*len(indent1) - 1
+	}
+	pad := indent - len(f.itemIndent(item))
+	if pad <= 0 {
+		return ""
+	}
+	return strings.Repeat(" ", pad)
+}
+
+// itemHang is the indent of the rest of the first block of a list item,
+// lined up with the text after the bullet.  Code and HTML blocks have
+// their lines taken as they are, so they get the indent of the blocks
+// after, and the bullet is padded to match.
+func (f *fmtRenderer) itemHang(item *bf.Node) string {
+	if hasRawFirstBlock(item) {
+		return f.itemIndent(item)
+	}
+	return strings.Repeat(" ", f.bulletWidth(item))
+}
+
+// hasRawFirstBlock is true if a list item starts with a code or HTML
+// block
+func hasRawFirstBlock(item *bf.Node) bool {
+	first := item.FirstChild
+	return first != nil && (first.Type == bf.CodeBlock || first.Type == bf.HTMLBlock)
+}
+
+// bulletWidth is the width of the bullets of a list, with the space
+// after.  Numbers are as wide as the widest.
+func (f *fmtRenderer) bulletWidth(item *bf.Node) int {
+	width := len(f.listBulletChar)
+	if item.ListFlags&bf.ListTypeOrdered != 0 {
+		width = f.listNumbers[item.Parent].Width() + 1
 	}
+	return width + len(f.listBulletSpace)
+}
 
+// isHTMLParagraph is true if the paragraph starts with an HTML tag.
+// Blackfriday only knows some block level tags, so others such as
+// <details> end up as paragraphs.
+func isHTMLParagraph(node *bf.Node) bool {
+	for child := node.FirstChild; child != nil; child = child.Next {
+		if child.Type == bf.Text && len(child.Literal) == 0 {
+			continue
+		}
+		return child.Type == bf.HTMLSpan
+	}
+	return false
 }
 
 func (f *fmtRenderer) Writer() *bytes.Buffer {
@@ -86,11 +369,50 @@ func (f *fmtRenderer) Writer() *bytes.Buffer {
 // Render does a generic walk
 func (f *fmtRenderer) Render(ast *bf.Node) []byte {
 	buf := new(bytes.Buffer)
-	f.bufs.Push(buf, "")
+	f.RenderHeader(buf, ast)
 	ast.Walk(func(node *bf.Node, entering bool) bf.WalkStatus {
 		return f.RenderNode(nil, node, entering)
 	})
-	f.bufs.Pop()
+	f.RenderFooter(buf, ast)
+	return buf.Bytes()
+}
+
+// RenderHeader sets up the output buffer
+func (f *fmtRenderer) RenderHeader(_ io.Writer, ast *bf.Node) {
+	f.bufs.Push(new(bytes.Buffer), "")
+
+	// only use the numbers from the source if the lists found there
+	// match the parsed ones
+	i := 0
+	ast.Walk(func(node *bf.Node, entering bool) bf.WalkStatus {
+		if entering && node.Type == bf.List && node.ListFlags&bf.ListTypeOrdered != 0 {
+			if i >= len(f.orderedLists) || len(f.orderedLists[i]) != countChildren(node) {
+				i = -1
+				return bf.Terminate
+			}
+			i++
+		}
+		return bf.GoToNext
+	})
+	if i != len(f.orderedLists) {
+		f.orderedLists = nil
+	}
+}
+
+func countChildren(node *bf.Node) int {
+	n := 0
+	for child := node.FirstChild; child != nil; child = child.Next {
+		n++
+	}
+	return n
+}
+
+// RenderFooter writes out the formatted document
+func (f *fmtRenderer) RenderFooter(w io.Writer, ast *bf.Node) {
+	if f.linkRefs != nil {
+		f.linkRefs.Flush(f.Writer())
+	}
+	buf, _ := f.bufs.Pop()
 	if len(f.bufs) != 0 {
 		panic("internal error, buffer stack underflow")
 	}
@@ -98,129 +420,143 @@ func (f *fmtRenderer) Render(ast *bf.Node) []byte {
 	if len(out) > 0 && out[len(out)-1] != '\n' {
 		buf.WriteByte('\n')
 	}
-	return buf.Bytes()
+	w.Write(buf.Bytes())
 }
 
 // RenderNode renders a node to a write
 func (f *fmtRenderer) RenderNode(_ io.Writer, node *bf.Node, entering bool) bf.WalkStatus {
+	if entering && node.Parent != nil && node.Parent.Type == bf.Item && node.Prev != nil && node.Prev.Prev == nil {
+		// the first block of the list item is done
+		f.bufs.Continue(f.itemIndent(node.Parent))
+	}
 	switch node.Type {
 	case bf.BlockQuote:
+		prefix := f.blockPad(node) + "> "
 		if entering {
-			out := f.Writer()
-			indent := "> "
-			if node.Parent.Type == bf.Item {
-				indent = indent1 + indent
-			}
-			if isPrevBlock(node) {
-				out.WriteString("\n\n")
-			}
-			f.bufs.Push(new(bytes.Buffer), indent)
+			f.writeSeparator(f.Writer(), node)
+			f.bufs.Push(new(bytes.Buffer), prefix)
 		} else {
-			ptext, _ := f.bufs.Pop()
-			out := f.Writer()
-			out.Write(ptext.Bytes())
-			if node.Parent.Type == bf.Item {
-				out.WriteByte('\n')
-			}
+			buf, _ := f.bufs.Pop()
+			f.Writer().Write(prefixLines(buf.Bytes(), prefix, prefix))
 		}
 	case bf.Paragraph:
 		if entering {
-			indent := ""
-			// handle case of
-			if node.Parent.Type == bf.Item && node.Prev != nil {
-				indent = indent1
-			}
-			f.bufs.Push(new(bytes.Buffer), indent)
+			f.bufs.Push(new(bytes.Buffer), "")
 		} else {
-			ptext, indent := f.bufs.Pop()
+			ptext, _ := f.bufs.Pop()
 			out := f.Writer()
-			if node.Parent.Type == bf.Item && node.Prev != nil {
-				// lists are special:  indent and bullet has already been
-				// printed.
-				out.WriteString("\n\n")
-				out.Write(WordWrap(ptext.Bytes(), f.linelen, indent, indent))
-				out.WriteByte('\n')
-			} else {
-				prefix := indent
-				if node.Parent.Type == bf.Item {
-					prefix = ""
-				}
-
-				if isPrevBlock(node) {
-					out.WriteString("\n\n")
-				}
-				out.Write(WordWrap(ptext.Bytes(), f.linelen, prefix, indent))
-			}
+			f.writeSeparator(out, node)
+			pad := f.blockPad(node)
+			out.Write(prefixLines(f.wrap(node, escapeLineStarts(ptext.Bytes())), pad, pad))
 		}
 	case bf.Document:
 		break
 	case bf.Text:
 		out := f.Writer()
-		out.Write(node.Literal)
-	case bf.Code:
+		if isEntity(node.Literal) {
+			// blackfriday keeps an entity, other than &amp;, as a node
+			// of its own
+			out.Write(node.Literal)
+			break
+		}
+		if len(node.Literal) != 0 && mayStartBlock(node.Literal[0]) && (node.Parent.Type == bf.Paragraph || node.Parent.Type == bf.Heading) {
+			out.WriteByte(escapeMarker)
+		}
+		text, prev := node.Literal, prevRune(node)
+		p := node.Prev
+		for p != nil && p.Type == bf.Text && len(p.Literal) == 0 {
+			p = p.Prev
+		}
+		if p != nil && p.Type == bf.HTMLSpan && !bytes.HasSuffix(p.Literal, []byte{'>'}) &&
+			len(text) != 0 && bytes.IndexByte(escapeChars, text[0]) != -1 {
+			// blackfriday ends a tag without a > at an escaped
+			// character, and would take the character otherwise
+			out.WriteByte('\\')
+			out.WriteByte(text[0])
+			text, prev = text[1:], rune(text[0])
+		}
+		out.Write(escapeText(text, textContextOf(node), prev, nextText(node)))
+	case bf.HTMLSpan:
+		// keep tags that start a line on their own line
 		out := f.Writer()
-		out.WriteByte('`')
+		if prev := node.Prev; prev != nil && prev.Type == bf.Text && bytes.HasSuffix(prev.Literal, []byte{'\n'}) {
+			out.WriteByte(lineBreakMarker)
+		}
 		out.Write(node.Literal)
-		out.WriteByte('`')
+	case bf.Softbreak:
+		f.Writer().WriteByte('\n')
+	case bf.Hardbreak:
+		f.Writer().WriteByte(hardBreakMarker)
+	case bf.HTMLBlock:
+		out := f.Writer()
+		f.writeSeparator(out, node)
+		out.Write(bytes.TrimRight(node.Literal, "\n"))
+	case bf.Code:
+		f.Writer().Write(codeSpan(node.Literal))
 	case bf.CodeBlock:
 		out := f.Writer()
-
-		indent := f.bufs.CurrentIndent()
-		switch node.Parent.Type {
-		case bf.Item:
-			indent += indent1
-			leftover := len(indent) % 4
-			// BF Bug: must be multiple of 4
-			//
-			if len(indent)%4 != 0 {
-				indent = indent[:len(indent)-leftover]
+		f.writeSeparator(out, node)
+		buf := bytes.Buffer{}
+		literal := unmarkCode(node.Literal)
+		if !node.IsFenced && f.indentedCode == "keep" {
+			for _, line := range splitLines(literal) {
+				if len(bytes.TrimSpace(line)) != 0 {
+					buf.WriteString(indent1)
+				}
+				buf.Write(line)
 			}
-			out.Write([]byte{'\n', '\n'})
-		case bf.BlockQuote:
-			break
-		default:
-			if isPrevBlock(node) {
-				out.WriteString("\n\n")
+		} else {
+			info := f.codeStyle.Info(string(node.CodeBlockData.Info))
+			code, _ := f.code.Format(info, literal)
+			if len(code) != 0 && code[len(code)-1] != '\n' {
+				code = append(code, '\n')
 			}
+			f.codeStyle.Write(&buf, info, code)
 		}
 
-		buf := bytes.Buffer{}
-		buf.WriteString("```")
-		if len(node.CodeBlockData.Info) != 0 {
-			buf.Write(node.CodeBlockData.Info)
-		}
-		buf.WriteByte('\n')
-		buf.Write(node.Literal)
-		buf.WriteString("```")
-
-		out.Write(writeIndent(buf.Bytes(), indent))
-		if node.Parent.Type == bf.Item {
-			out.WriteByte('\n')
-		}
+		out.Write(bytes.TrimSuffix(buf.Bytes(), []byte{'\n'}))
 	case bf.Del:
 		f.Writer().WriteString("~~")
 	case bf.Emph:
-		f.Writer().WriteByte('*')
+		f.Writer().WriteString(f.emphasisMarker(node))
 	case bf.Strong:
-		f.Writer().WriteString("**")
+		f.Writer().WriteString(f.emphasisMarker(node))
 	case bf.Heading:
 		if entering {
 			f.bufs.Push(new(bytes.Buffer), "")
 		} else {
-			inner, _ := f.bufs.Pop()
+			buf, _ := f.bufs.Pop()
+			inner := bytes.NewBuffer(escapeLineStarts(buf.Bytes()))
 			out := f.Writer()
-			if isPrevBlock(node) {
+			flushed := false
+			if f.linkRefs != nil && f.linkRefSection && node.Parent.Type == bf.Document {
+				// end of the previous section
+				flushed = f.linkRefs.Flush(out)
+			}
+			if flushed {
 				out.WriteString("\n\n")
+			} else {
+				f.writeSeparator(out, node)
+			}
+			level := node.HeadingData.Level
+			if f.headingStyle == "atx" || level >= 3 {
+				out.Write(bytes.Repeat([]byte{'#'}, level))
+				out.WriteByte(' ')
+				out.Write(inner.Bytes())
+				break
+			}
+			underline := "="
+			if level == 2 {
+				underline = "-"
 			}
-			out.Write(bytes.Repeat([]byte{'#'}, node.HeadingData.Level))
-			out.WriteByte(' ')
 			out.Write(inner.Bytes())
+			out.WriteByte('\n')
+			out.WriteString(strings.Repeat(underline, runewidth.StringWidth(inner.String())))
 		}
 	case bf.HorizontalRule:
 		out := f.Writer()
-		out.Write([]byte{'\n'})
-		out.Write([]byte{'-', '-', '-'})
-		out.Write([]byte{'\n'})
+		f.writeSeparator(out, node)
+		out.WriteString(f.hrText)
 	case bf.Image:
 		if entering {
 			f.bufs.Push(new(bytes.Buffer), "")
@@ -238,7 +574,7 @@ func (f *fmtRenderer) RenderNode(_ io.Writer, node *bf.Node, entering bool) bf.W
 				out.WriteByte(' ')
 			}
 			out.WriteByte('(')
-			out.Write(node.LinkData.Destination)
+			writeLinkTarget(out, node.LinkData)
 			out.WriteByte(')')
 		}
 	case bf.Link:
@@ -247,70 +583,94 @@ func (f *fmtRenderer) RenderNode(_ io.Writer, node *bf.Node, entering bool) bf.W
 		} else {
 			buf, _ := f.bufs.Pop()
 			linktext := buf.Bytes()
-			// TODO add link title info
 			out := f.Writer()
+			if url := autoLinkURL(node); url != nil {
+				if written := f.autoLinks.next(url); written.form != autoLinkExplicit {
+					f.autoLinks.write(out, url, written)
+					break
+				}
+			}
 			out.WriteByte('[')
 			out.Write(linktext)
 			out.WriteByte(']')
 			if !f.linkTight {
 				out.WriteByte(' ')
 			}
+			if f.linkRefs != nil && !isAutoLink(linktext, node.LinkData.Destination) {
+				out.WriteString("[" + f.linkRefs.Label(node.LinkData.Destination, node.LinkData.Title) + "]")
+				break
+			}
 			out.WriteByte('(')
-			out.Write(node.LinkData.Destination)
+			writeLinkTarget(out, node.LinkData)
 			out.WriteByte(')')
 		}
 	case bf.List:
 		if entering {
-			indent := ""
-			if f.listDepth > 0 {
-				indent = indent1
-			}
-			f.bufs.Push(new(bytes.Buffer), indent)
-			f.listDepth++
+			f.bufs.Push(new(bytes.Buffer), "")
 			if node.ListFlags&bf.ListTypeOrdered != 0 {
-				f.olCount[node] = 0
+				var written []string
+				if len(f.orderedLists) != 0 {
+					written, f.orderedLists = f.orderedLists[0], f.orderedLists[1:]
+				}
+				f.listNumbers[node] = &listNumbers{mode: f.listNumbering, written: written, count: countChildren(node)}
 			}
 		} else {
 			buf, _ := f.bufs.Pop()
-			listtext := buf.Bytes()
 			out := f.Writer()
-			if isPrevBlock(node) {
-				out.WriteString("\n\n")
-			}
-			out.Write(listtext)
-
-			f.listDepth--
-			if f.listDepth < 0 {
-				panic("underflow listdepth")
-			}
-			delete(f.olCount, node)
+			f.writeSeparator(out, node)
+			out.Write(buf.Bytes())
+			delete(f.listNumbers, node)
 		}
 	case bf.Item:
 		if entering {
 			// generate bullet
 			buf := bytes.Buffer{}
 			if node.ListFlags&bf.ListTypeOrdered != 0 {
-				f.olCount[node.Parent]++
-				buf.WriteString(strconv.Itoa(f.olCount[node.Parent]))
+				buf.WriteString(f.listNumbers[node.Parent].Next())
 				buf.WriteByte('.')
 			} else {
-				buf.WriteByte('-')
+				buf.WriteString(f.listBulletChar)
+			}
+			buf.WriteString(f.listBulletSpace)
+			if hasRawFirstBlock(node) {
+				for buf.Len() < len(f.itemIndent(node)) {
+					buf.WriteByte(' ')
+				}
 			}
-			buf.WriteByte(' ')
-			bullet := buf.Bytes()
 
-			out := f.Writer()
-			out.WriteString(strings.Repeat(indent1, f.listDepth-1))
-			out.Write(bullet)
-			f.bufs.Push(new(bytes.Buffer), strings.Repeat(" ", len(bullet)))
+			f.writeSeparator(f.Writer(), node)
+			f.bufs.Push(&buf, f.itemHang(node))
 		} else {
+			first := f.bufs.FirstBlock()
 			buf, _ := f.bufs.Pop()
 			text := buf.Bytes()
 			out := f.Writer()
-			out.Write(text)
-			out.WriteByte('\n')
+			out.Write(prefixLines(text[:first], "", f.itemHang(node)))
+			out.Write(prefixLines(text[first:], "", f.itemIndent(node)))
 		}
 
+	case bf.Table:
+		if entering {
+			f.table = &table{}
+		} else {
+			out := f.Writer()
+			f.writeSeparator(out, node)
+			out.Write(f.table.Render(f.tableCompact))
+			f.table = nil
+		}
+	case bf.TableHead, bf.TableBody:
+		break
+	case bf.TableRow:
+		if entering {
+			f.table.rows = append(f.table.rows, nil)
+		}
+	case bf.TableCell:
+		if entering {
+			f.bufs.Push(new(bytes.Buffer), "")
+		} else {
+			buf, _ := f.bufs.Pop()
+			f.table.AddCell(buf.String(), node.TableCellData)
+		}
 	default:
 		log.Printf("RENDER NODE: [%v] %+v", entering, *node)
 	}
@@ -318,7 +678,296 @@ func (f *fmtRenderer) RenderNode(_ io.Writer, node *bf.Node, entering bool) bf.W
 }
 
 // Fmt2 reformats Markdown using BlackFriday v2
-func Fmt2(input []byte) []byte {
-	r := newFmtRenderer()
-	return bf.Run(input, bf.WithRenderer(r))
+// If opt is nil the defaults are used.  A document with a control
+// character used as a marker, see hasMarkers, is returned as it is.
+func Fmt2(input []byte, opt *FmtOptions) []byte {
+	if hasMarkers(input) {
+		return input
+	}
+	enc, input := splitEncoding(input)
+	fm, body := SplitFrontMatter(input)
+	output := fmt2Body(body, opt)
+	return enc.join(joinFrontMatter(fm, body, output, opt), lineEnding(opt))
+}
+
+// fmt2Body formats Markdown without any front matter, byte order mark
+// or \r\n line endings
+func fmt2Body(input []byte, opt *FmtOptions) []byte {
+	renumber := opt != nil && opt.FootnoteRenumber
+	input, notes := extractFootnotes(input, renumber)
+	input, refs := extractLinkRefs(input)
+	input, infos := extractCodeInfo(input)
+	r := newFmtRenderer(opt)
+	r.orderedLists = scanOrderedLists(input)
+	r.codeStyle.infos = infos
+	r.autoLinks = scanAutoLinks(input, opt)
+	if opt != nil && opt.LinkStyle == "reference" {
+		r.linkRefs = newLinkRefs(refs)
+	}
+	output := stripEscapeMarkers(r.Render(parseFmt2(input)))
+	output = restoreCodeInfo(output, infos)
+	output = restoreLinkRefs(output, refs)
+	return restoreFootnotes(output, notes, renumber, func(note []byte) []byte {
+		return fmt2Body(note, noteOptions(opt))
+	})
+}
+
+// parseFmt2 parses the source with the lines of code marked, see
+// markCodeLines
+func parseFmt2(input []byte) *bf.Node {
+	doc := bf.New(bf.WithExtensions(bf.CommonExtensions)).Parse(markCodeLines(input))
+	if marksInCode(doc) {
+		return doc
+	}
+	return bf.New(bf.WithExtensions(bf.CommonExtensions)).Parse(input)
+}
+
+// isAutoLink is true if the link text is the URL, e.g. <https://golang.org/>
+func isAutoLink(text []byte, dest []byte) bool {
+	return bytes.Equal(text, bytes.TrimPrefix(dest, []byte("mailto:")))
+}
+
+// autoLinkURL returns the URL of a link that could have been an
+// autolink, where the text is the URL without escapes, or nil
+func autoLinkURL(node *bf.Node) []byte {
+	text := node.FirstChild
+	if text == nil || text.Next != nil || text.Type != bf.Text {
+		return nil
+	}
+	if !isAutoLink(text.Literal, node.LinkData.Destination) {
+		return nil
+	}
+	return text.Literal
+}
+
+// writeLinkTarget writes the destination and title of an inline link
+func writeLinkTarget(out *bytes.Buffer, link bf.LinkData) {
+	out.WriteString(linkDestination(link.Destination))
+	if len(link.Title) != 0 {
+		out.WriteByte(' ')
+		out.WriteString(linkTitle(link.Title, false))
+	}
+}
+
+// emphasisMarker returns the marker for an Emph or Strong node.
+// Emphasis inside other emphasis using the same character uses the other
+// one if it is at the start or end, see nestedEmphasisMarker, or is the
+// same kind, since *a *b* c* would end at the first b.  Emphasis that is
+// all of the other kind's text can use the same one, as in ***a***.  Emphasis around
+// emphasis inside a word, which must use *, uses _.
+func (f *fmtRenderer) emphasisMarker(node *bf.Node) string {
+	char, n := f.emphasisChar, 1
+	if node.Type == bf.Strong {
+		char, n = f.strongChar, 2
+	}
+	intraword := isIntraword(node)
+	marker := emphasisMarker(char, n, intraword)
+	if intraword {
+		return marker
+	}
+	parent := node.Parent
+	if parent.Type == bf.Emph || parent.Type == bf.Strong {
+		first, last := isFirstChild(node), isLastChild(node)
+		if parent.Type != node.Type && first && last {
+			// ***a***
+			return marker
+		}
+		if (parent.Type == node.Type || first || last) && f.emphasisMarker(parent)[0] == marker[0] {
+			return strings.Repeat(otherEmphasisChar(marker), n)
+		}
+		return marker
+	}
+	for c := node.FirstChild; c != nil; c = c.Next {
+		if (c.Type == bf.Emph || c.Type == bf.Strong) && isIntraword(c) &&
+			(c.Type == node.Type || isFirstChild(c) || isLastChild(c)) {
+			return strings.Repeat("_", n)
+		}
+	}
+	return marker
+}
+
+// isFirstChild is true if a node has no siblings before it, other than
+// empty text
+func isFirstChild(node *bf.Node) bool {
+	for n := node.Prev; n != nil; n = n.Prev {
+		if n.Type != bf.Text || len(n.Literal) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// isLastChild is true if a node has no siblings after it, other than
+// empty text
+func isLastChild(node *bf.Node) bool {
+	for n := node.Next; n != nil; n = n.Next {
+		if n.Type != bf.Text || len(n.Literal) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// textContextOf returns where the text of a node is written
+func textContextOf(node *bf.Node) textContext {
+	for n := node.Parent; n != nil; n = n.Parent {
+		switch n.Type {
+		case bf.Link, bf.Image:
+			return inLinkText
+		case bf.TableCell:
+			return inTableCell
+		case bf.Heading:
+			return inHeading
+		}
+	}
+	return inText
+}
+
+// prevRune returns the character written before a Text node, for
+// escapeText
+func prevRune(node *bf.Node) rune {
+	for n := node.Prev; n != nil; n = n.Prev {
+		switch {
+		case n.Type == bf.Text && len(n.Literal) == 0:
+			continue
+		case n.Type == bf.Text:
+			r, _ := utf8.DecodeLastRune(n.Literal)
+			return r
+		case n.Type == bf.Hardbreak:
+			return '\n'
+		}
+		return unknownRune
+	}
+	return blockEdge(node.Parent)
+}
+
+// nextText returns the text written after a Text node, up to the next
+// node that isn't text
+func nextText(node *bf.Node) []byte {
+	var text []byte
+	for n := node.Next; n != nil; n = n.Next {
+		switch n.Type {
+		case bf.Text:
+			text = append(text, n.Literal...)
+			continue
+		case bf.Hardbreak:
+			return append(text, '\n')
+		}
+		return append(text, unknownText...)
+	}
+	if blockEdge(node.Parent) != 0 {
+		text = append(text, unknownText...)
+	}
+	return text
+}
+
+// blockEdge is the character before or after the text of a node: none
+// for a block, or the markup of an inline node
+func blockEdge(node *bf.Node) rune {
+	switch node.Type {
+	case bf.Paragraph, bf.Heading, bf.TableCell:
+		return 0
+	}
+	return unknownRune
+}
+
+// table collects the cells of a table so the columns can be aligned
+type table struct {
+	rows   [][]string
+	aligns []bf.CellAlignFlags
+}
+
+// AddCell adds a cell to the last row.  Pipes are escaped
+// since they would otherwise end the cell.
+func (t *table) AddCell(text string, data bf.TableCellData) {
+	text = escapePipes(strings.TrimSpace(text))
+	row := len(t.rows) - 1
+	if data.IsHeader {
+		t.aligns = append(t.aligns, data.Align)
+	}
+	t.rows[row] = append(t.rows[row], text)
+}
+
+// escapePipes backslash escapes any '|' that isn't already
+func escapePipes(text string) string {
+	buf := bytes.Buffer{}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '|' && (i == 0 || text[i-1] != '\\') {
+			buf.WriteByte('\\')
+		}
+		buf.WriteByte(text[i])
+	}
+	return buf.String()
+}
+
+// Render writes out the table, without a trailing newline.
+// If compact is true, cells are not padded to align the columns.
+func (t *table) Render(compact bool) []byte {
+	widths := make([]int, len(t.aligns))
+	if !compact {
+		for i := range widths {
+			widths[i] = 3
+		}
+		for _, row := range t.rows {
+			for i, cell := range row {
+				if w := runewidth.StringWidth(cell); i < len(widths) && w > widths[i] {
+					widths[i] = w
+				}
+			}
+		}
+	}
+
+	out := bytes.Buffer{}
+	for r, row := range t.rows {
+		if r != 0 {
+			out.WriteByte('\n')
+		}
+		out.WriteByte('|')
+		for i := range widths {
+			cell := ""
+			if i < len(row) {
+				cell = row[i]
+			}
+			out.WriteByte(' ')
+			out.WriteString(pad(cell, widths[i], t.aligns[i]))
+			out.WriteString(" |")
+		}
+		if r != 0 {
+			continue
+		}
+
+		// line between header and body
+		out.WriteString("\n|")
+		for i, width := range widths {
+			if width < 3 {
+				width = 3
+			}
+			sep := []byte(strings.Repeat("-", width))
+			if t.aligns[i]&bf.TableAlignmentLeft != 0 {
+				sep[0] = ':'
+			}
+			if t.aligns[i]&bf.TableAlignmentRight != 0 {
+				sep[len(sep)-1] = ':'
+			}
+			out.WriteByte(' ')
+			out.Write(sep)
+			out.WriteString(" |")
+		}
+	}
+	return out.Bytes()
+}
+
+// pad pads a table cell out to width, using the column alignment
+func pad(cell string, width int, align bf.CellAlignFlags) string {
+	spaces := width - runewidth.StringWidth(cell)
+	if spaces <= 0 {
+		return cell
+	}
+	switch align {
+	case bf.TableAlignmentRight:
+		return strings.Repeat(" ", spaces) + cell
+	case bf.TableAlignmentCenter:
+		return strings.Repeat(" ", spaces/2) + cell + strings.Repeat(" ", spaces-spaces/2)
+	}
+	return cell + strings.Repeat(" ", spaces)
 }
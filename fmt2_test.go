@@ -3,33 +3,12 @@ package mdtool
 import (
 	"bytes"
 	"io/ioutil"
-	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
 	//        bf "gopkg.in/russross/blackfriday.v2"
 )
 
-// from
-//
-func diff(orig string, b2 []byte) (data []byte, err error) {
-	f2, err := ioutil.TempFile("", "markdownfmt")
-	if err != nil {
-		return
-	}
-	defer os.Remove(f2.Name())
-	f2.Write(b2)
-	f2.Close()
-	data, err = exec.Command("diff", "-u", orig, f2.Name()).CombinedOutput()
-	if len(data) > 0 {
-		// diff exits with a non-zero status when the files don't match.
-		// Ignore that failure as long as we get output.
-		err = nil
-	}
-	return
-}
-
 var fmtcases = [][2]string{
 	{"# h1", "# h1"},
 	{"# *h1*", "# *h1*"},
@@ -51,13 +30,127 @@ func TestFmt2(t *testing.T) {
 	for idx, tt := range fmtcases {
 		src := []byte(tt[0])
 		want := strings.TrimSpace(tt[1])
-		got := strings.TrimSpace(string(Fmt2(src)))
+		got := strings.TrimSpace(string(Fmt2(src, nil)))
 		if got != want {
 			t.Errorf("Case %d)\n'%s'\n'%s'", idx, want, got)
 		}
 	}
 }
 
+func TestFmt2Options(t *testing.T) {
+	opt := &FmtOptions{
+		LineLength:      20,
+		ListBulletChar:  "*",
+		ListIndent:      "  ",
+		ListBulletSpace: " ",
+		HeadingStyle:    "setext",
+		HrChar:          "*",
+		HrLength:        5,
+	}
+	cases := [][2]string{
+		{"# h1", "h1\n=="},
+		{"## h2", "h2\n--"},
+		{"### h3", "### h3"},
+		{"- 1\n- 2\n", "* 1\n* 2"},
+		{"a\n\n---\n", "a\n\n*****"},
+		{"one two three four five six", "one two three four\nfive six"},
+		{"- one two three four five six", "* one two three\n  four five six"},
+	}
+	for idx, tt := range cases {
+		got := strings.TrimSpace(string(Fmt2([]byte(tt[0]), opt)))
+		if got != tt[1] {
+			t.Errorf("Case %d)\n'%s'\n'%s'", idx, tt[1], got)
+		}
+	}
+}
+
+func TestFmt2Tables(t *testing.T) {
+	src := "a|b|c\n:--|:-:|--:\nlong cell|x|y\n`a\\|b`|日本語|z\n"
+	cases := []struct {
+		compact bool
+		want    string
+	}{
+		{false, "| a         |   b    |   c |\n" +
+			"| :-------- | :----: | --: |\n" +
+			"| long cell |   x    |   y |\n" +
+			"| `a\\|b`    | 日本語 |   z |"},
+		{true, "| a | b | c |\n" +
+			"| :-- | :-: | --: |\n" +
+			"| long cell | x | y |\n" +
+			"| `a\\|b` | 日本語 | z |"},
+	}
+	for idx, tt := range cases {
+		got := strings.TrimSpace(string(Fmt2([]byte(src), &FmtOptions{TableCompact: tt.compact})))
+		if got != tt.want {
+			t.Errorf("Case %d)\n%s\n%s", idx, tt.want, got)
+		}
+	}
+}
+
+func TestFmt2HTMLAndBreaks(t *testing.T) {
+	cases := []struct {
+		style string
+		src   string
+		want  string
+	}{
+		{"", "a  \nb\\\nc", "a  \nb  \nc"},
+		{"backslash", "a  \nb\\\nc", "a\\\nb\\\nc"},
+		{"", "a <b>bold</b>\nb", "a <b>bold</b> b"},
+		{"", "<details>\n<summary>Hi</summary>\n\ntext\n</details>",
+			"<details>\n<summary>Hi</summary>\n\ntext\n</details>"},
+		{"", "para\n\n<!-- comment -->\n\npara", "para\n\n<!-- comment -->\n\npara"},
+		{"", "<div>\n  <p>hi</p>\n</div>", "<div>\n  <p>hi</p>\n</div>"},
+	}
+	for idx, tt := range cases {
+		opt := &FmtOptions{LineLength: 70, HardBreak: tt.style}
+		got := strings.TrimSpace(string(Fmt2([]byte(tt.src), opt)))
+		if got != tt.want {
+			t.Errorf("Case %d)\n%q\n%q", idx, tt.want, got)
+		}
+	}
+}
+
+func TestFmt2Links(t *testing.T) {
+	cases := []struct {
+		style   string
+		section bool
+		src     string
+		want    string
+	}{
+		{"", false, `[a](/b "title")`, `[a](/b "title")`},
+		{"", false, `[a](/b 'say "hi"')`, `[a](/b 'say "hi"')`},
+		{"", false, `[a](/b "say \"hi\" 'there'")`, `[a](/b "say \"hi\" 'there'")`},
+		{"", false, "[a][r]\n\n[r]: /b (say \"hi\" 'there')", "[a][r]\n\n[r]: /b (say \"hi\" 'there')"},
+		{"", false, "[a][r]\n\n> quote\n>\n> [r]: /x \"t\"\n\nafter",
+			"[a][r]\n\n> quote\n>\n> [r]: /x \"t\"\n\nafter"},
+		{"", false, "[a][r]\n\n- one\n\n    [r]: /x\n    [s]: /y\n- two",
+			"[a][r]\n\n- one\n\n      [r]: /x\n      [s]: /y\n\n- two"},
+		{"", false, "[a][r]\n\n- one\n  [r]: /x\n- two\n\n[r]: /y",
+			"[a][r]\n\n- one \\[r]: /x\n- two\n\n[r]: /y"},
+		{"", false, `![alt](/img.png "pic")`, `![alt](/img.png "pic")`},
+		{"", false, "see [a][ref] and [ref]\n\n[ref]: /b  'title'",
+			"see [a][ref] and [ref]\n\n[ref]: /b \"title\""},
+		{"", false, "[a][x]\n\n[x]: /x\n[y]: /y\n  \"why\"\n\nafter",
+			"[a][x]\n\n[x]: /x\n[y]: /y \"why\"\n\nafter"},
+		{"reference", false, "[a](/a) [b](/b \"t\") [c](/a)\n\n## Two\n\n[d](/d)",
+			"[a][1] [b][2] [c][1]\n\n## Two\n\n[d][3]\n\n[1]: /a\n[2]: /b \"t\"\n[3]: /d"},
+		{"reference", true, "[a](/a)\n\n## Two\n\n[d](/d)",
+			"[a][1]\n\n[1]: /a\n\n## Two\n\n[d][2]\n\n[2]: /d"},
+		{"reference", false, "[a](/a) [b][1]\n\n[1]: /b",
+			"[a][2] [b][1]\n\n[1]: /b\n\n[2]: /a"},
+	}
+	for idx, tt := range cases {
+		opt := &FmtOptions{LineLength: 70, LinkStyle: tt.style}
+		if tt.section {
+			opt.LinkRefPlacement = "section"
+		}
+		got := strings.TrimSpace(string(Fmt2([]byte(tt.src), opt)))
+		if got != tt.want {
+			t.Errorf("Case %d)\n%q\n%q", idx, tt.want, got)
+		}
+	}
+}
+
 func TestFmt2Fixtures(t *testing.T) {
 	files, err := filepath.Glob("fixtures/*.md")
 	if err != nil {
@@ -68,13 +161,33 @@ func TestFmt2Fixtures(t *testing.T) {
 		if err != nil {
 			t.Errorf("Unable to read %q: %s", filename, err)
 		}
-		got := Fmt2(raw)
+		got := Fmt2(raw, nil)
 		if !bytes.Equal(raw, got) {
-			df, err := diff(filename, got)
-			if err != nil {
-				t.Errorf("Unable to diff: %s", err)
-			}
+			df := Diff(filename, raw, filename+".formatted", got)
 			t.Errorf("File %q did not match:\n%s", filename, df)
 		}
 	}
 }
+
+func TestFmtPlaceholderText(t *testing.T) {
+	cases := []struct {
+		src  string
+		want string
+	}{
+		{"MDTOOLSFOOTNOTE0END and MDTOOLSLINKREF0END[^1]\n\n[^1]: Note.\n",
+			"MDTOOLSFOOTNOTE0END and MDTOOLSLINKREF0END[^1]\n\n[^1]: Note.\n"},
+		{"```text MDTOOLSINFO0END\nMDTOOLSCODELINE- x\n```\n", "```text MDTOOLSINFO0END\nMDTOOLSCODELINE- x\n```\n"},
+
+		// marker bytes, returned as they are
+		{"a\x00b  *c*\n", "a\x00b  *c*\n"},
+		{"a\x02b  *c*\n", "a\x02b  *c*\n"},
+	}
+	for idx, tt := range cases {
+		for _, format := range []func([]byte, *FmtOptions) []byte{Fmt, Fmt2} {
+			got := strings.TrimLeft(string(format([]byte(tt.src), nil)), "\n")
+			if got != tt.want {
+				t.Errorf("Case %d)\n%q\n%q", idx, tt.want, got)
+			}
+		}
+	}
+}
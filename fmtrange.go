@@ -0,0 +1,207 @@
+package mdtool
+
+import (
+	"bytes"
+	"sort"
+)
+
+// TextEdit replaces the bytes from Start up to End with Text
+type TextEdit struct {
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Text  string `json:"text"`
+}
+
+// FmtRange formats only the blocks of src that overlap the range from
+// start to end, using Fmt.  The range is expanded to whole top level
+// blocks, such as a paragraph or an entire list.  The changes are
+// returned as edits to src, in order, and nothing outside of the
+// expanded range is changed.  References in the range to link and
+// footnote definitions elsewhere are kept, see formatInContext.
+func FmtRange(src []byte, start, end int, opt *FmtOptions) []TextEdit {
+	return formatRange(src, start, end, opt, Fmt)
+}
+
+// Fmt2Range is FmtRange using Fmt2
+func Fmt2Range(src []byte, start, end int, opt *FmtOptions) []TextEdit {
+	return formatRange(src, start, end, opt, Fmt2)
+}
+
+// ApplyEdits returns src with the edits made.  The edits must not
+// overlap.
+func ApplyEdits(src []byte, edits []TextEdit) []byte {
+	sorted := append([]TextEdit(nil), edits...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+	out := bytes.Buffer{}
+	last := 0
+	for _, e := range sorted {
+		out.Write(src[last:e.Start])
+		out.WriteString(e.Text)
+		last = e.End
+	}
+	out.Write(src[last:])
+	return out.Bytes()
+}
+
+func formatRange(src []byte, start, end int, opt *FmtOptions, format func([]byte, *FmtOptions) []byte) []TextEdit {
+	if start < 0 {
+		start = 0
+	}
+	if end > len(src) {
+		end = len(src)
+	}
+	if end < start {
+		end = start
+	}
+
+	// expand to the blocks the range touches
+	from, to := -1, -1
+	for _, b := range topLevelBlocks(src) {
+		overlaps := b[0] < end && start < b[1]
+		if start == end {
+			overlaps = b[0] <= start && start < b[1]
+		}
+		if !overlaps {
+			continue
+		}
+		if from == -1 {
+			from = b[0]
+		}
+		to = b[1]
+	}
+	if from == -1 {
+		return nil
+	}
+
+	orig := src[from:to]
+	formatted := bytes.Replace(formatInContext(src, from, to, opt, format), crlf, lf, -1)
+	formatted = bytes.TrimLeft(formatted, "\n")
+	formatted = bytes.TrimRight(formatted, " \t\n")
+	if bytes.HasSuffix(orig, []byte{'\n'}) {
+		formatted = append(formatted, '\n')
+	}
+	// use the document's line ending rather than the range's
+	enc, _ := splitEncoding(src)
+	formatted = textEncoding{crlf: enc.crlf}.join(formatted, lineEnding(opt))
+	if bytes.Equal(orig, formatted) {
+		return nil
+	}
+
+	// turn the changed lines into edits
+	var edits []TextEdit
+	offset := from
+	var edit *TextEdit
+	for _, line := range diffLines(splitLines(orig), splitLines(formatted)) {
+		if line.op == ' ' {
+			if edit != nil {
+				edits = append(edits, *edit)
+				edit = nil
+			}
+			offset += len(line.text)
+			continue
+		}
+		if edit == nil {
+			edit = &TextEdit{Start: offset, End: offset}
+		}
+		if line.op == '-' {
+			offset += len(line.text)
+			edit.End = offset
+		} else {
+			edit.Text += string(line.text)
+		}
+	}
+	if edit != nil {
+		edits = append(edits, *edit)
+	}
+	return edits
+}
+
+// formatInContext formats src[from:to] followed by the definitions in
+// the rest of the document, so that reference links and footnotes in
+// the range read the same as in the whole document, and then cuts the
+// definitions from the result.  Footnotes aren't renumbered, links keep
+// their style, and front matter is kept as written.
+func formatInContext(src []byte, from, to int, opt *FmtOptions, format func([]byte, *FmtOptions) []byte) []byte {
+	if opt != nil {
+		rangeOpt := *opt
+		rangeOpt.FootnoteRenumber = false
+		rangeOpt.LinkStyle = ""
+		rangeOpt.FrontMatterFormat = "keep"
+		opt = &rangeOpt
+	}
+
+	end := placeholder("RANGEEND", 0)
+	text := append([]byte{}, src[from:to]...)
+	if !bytes.HasSuffix(text, lf) {
+		text = append(text, '\n')
+	}
+	text = append(text, "\n"+end+"\n\n"...)
+	code := fencedCode(src)
+	for offset := 0; offset < len(src); {
+		if e := inRange(code, offset); e != -1 {
+			offset = e
+			continue
+		}
+		line := nextLine(src, offset)
+		offset += len(line)
+		if offset > from && offset-len(line) < to {
+			continue
+		}
+		def := bytes.TrimLeft(line[len(quotePrefix.Find(line)):], " \t")
+		if linkRefDef.Match(def) || footnoteDef.Match(def) {
+			text = append(append(text, bytes.TrimRight(def, "\r\n")...), "\n\n"...)
+		}
+	}
+
+	formatted := format(text, opt)
+	if i := bytes.Index(formatted, []byte(end)); i != -1 {
+		formatted = formatted[:i]
+	}
+	return formatted
+}
+
+// topLevelBlocks splits a document into blocks that can be formatted on
+// their own: runs of lines separated by blank lines.  Indented lines
+// belong to the block before them, as do list items following a list,
+// and front matter and fenced code are never split.  Each block ends
+// after the newline of its last line.
+func topLevelBlocks(src []byte) [][2]int {
+	var blocks [][2]int
+	code := fencedCode(src)
+	start, end := -1, -1
+	blank := false
+	isList := false // the current block is a list
+	offset := 0
+	if fm, _ := SplitFrontMatter(src); fm != nil {
+		blocks = append(blocks, [2]int{0, len(fm.Raw)})
+		offset = len(fm.Raw)
+	}
+	for offset < len(src) {
+		line := nextLine(src, offset)
+		size := len(line)
+		if e := inRange(code, offset); e != -1 {
+			size = e - offset
+		}
+		if len(bytes.TrimSpace(line)) == 0 && size == len(line) {
+			blank = true
+			offset += size
+			continue
+		}
+		item := listMarker(line) != 0
+		indented := line[0] == ' ' || line[0] == '\t'
+		if start == -1 || (blank && !indented && !(isList && item)) {
+			if start != -1 {
+				blocks = append(blocks, [2]int{start, end})
+			}
+			start = offset
+			isList = item
+		}
+		end = offset + size
+		blank = false
+		offset += size
+	}
+	if start != -1 {
+		blocks = append(blocks, [2]int{start, end})
+	}
+	return blocks
+}
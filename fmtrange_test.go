@@ -0,0 +1,96 @@
+package mdtool
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFmtRange(t *testing.T) {
+	doc := "#  Title\n\nfirst   paragraph\n\n*  one\n\n*  two\n\n```\ncode\n\n   more\n```\n\nlast   paragraph\n"
+	cases := []struct {
+		start, end string // the range is from start to the end of end, or empty
+		want       string
+	}{
+		{"first", "first", "#  Title\n\nfirst paragraph\n\n*  one\n\n*  two\n\n```\ncode\n\n   more\n```\n\nlast   paragraph\n"},
+		{"two", "two", "#  Title\n\nfirst   paragraph\n\n* one\n\n* two\n\n```\ncode\n\n   more\n```\n\nlast   paragraph\n"},
+		{"more", "last", "#  Title\n\nfirst   paragraph\n\n*  one\n\n*  two\n\n```\ncode\n\n   more\n```\n\nlast paragraph\n"},
+		{"Title", "Title", "# Title\n\nfirst   paragraph\n\n*  one\n\n*  two\n\n```\ncode\n\n   more\n```\n\nlast   paragraph\n"},
+		{"\nfirst", "", doc},
+	}
+	for idx, tt := range cases {
+		start := strings.Index(doc, tt.start)
+		end := start
+		if tt.end != "" {
+			end = strings.Index(doc, tt.end) + len(tt.end)
+		}
+		edits := FmtRange([]byte(doc), start, end, nil)
+		got := string(ApplyEdits([]byte(doc), edits))
+		if got != tt.want {
+			t.Errorf("Case %d)\n%q\n%q", idx, tt.want, got)
+		}
+	}
+}
+
+func TestFmtRangeDefinitions(t *testing.T) {
+	doc := "See  [the docs][d].\n\nPara one[^1].\n\nPara  two[^2].\n\n[d]: http://x.com\n\n[^1]: One.\n\n[^2]: Two.\n"
+	cases := []struct {
+		start   string
+		want    string
+		fmtLink string // Fmt writes reference links inline
+	}{
+		{"See", "See [the docs][d].\n\nPara one[^1].\n\nPara  two[^2].\n\n[d]: http://x.com\n\n[^1]: One.\n\n[^2]: Two.\n",
+			"[the docs](http://x.com)"},
+		{"Para  two", "See  [the docs][d].\n\nPara one[^1].\n\nPara two[^2].\n\n[d]: http://x.com\n\n[^1]: One.\n\n[^2]: Two.\n", ""},
+	}
+	opt := &FmtOptions{FootnoteRenumber: true, LinkStyle: "reference"}
+	for name, formatRange := range map[string]func([]byte, int, int, *FmtOptions) []TextEdit{"Fmt": FmtRange, "Fmt2": Fmt2Range} {
+		for idx, tt := range cases {
+			start := strings.Index(doc, tt.start)
+			got := ApplyEdits([]byte(doc), formatRange([]byte(doc), start, start, opt))
+			want := tt.want
+			if name == "Fmt" && tt.fmtLink != "" {
+				want = strings.Replace(want, "[the docs][d]", tt.fmtLink, 1)
+			}
+			if string(got) != want {
+				t.Errorf("%s case %d)\n%q\n%q", name, idx, want, got)
+			}
+			if err := Verify([]byte(doc), got); err != nil {
+				t.Errorf("%s case %d) %s", name, idx, err)
+			}
+		}
+	}
+}
+
+func TestFmtRangeLineEndings(t *testing.T) {
+	doc := "#  Title\r\n\r\nfirst   paragraph\r\nmore\n\r\nlast\r\n"
+	want := "#  Title\r\n\r\nfirst paragraph more\r\n\r\nlast\r\n"
+	start := strings.Index(doc, "first")
+	got := string(ApplyEdits([]byte(doc), FmtRange([]byte(doc), start, start, nil)))
+	if got != want {
+		t.Errorf("\n%q\n%q", want, got)
+	}
+}
+
+func TestFmtRangeFixtures(t *testing.T) {
+	files, err := filepath.Glob("fixtures/*.md")
+	if err != nil {
+		t.Fatalf("Unable to get testcase files: %s", err)
+	}
+	for _, name := range append(files, "mdtest.md") {
+		raw, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatalf("Unable to read %q: %s", name, err)
+		}
+		for _, b := range topLevelBlocks(raw) {
+			edits := Fmt2Range(raw, b[0], b[1], nil)
+			got := ApplyEdits(raw, edits)
+			// everything before and after the block is unchanged
+			if !bytes.HasPrefix(got, raw[:b[0]]) || !bytes.HasSuffix(got, raw[b[1]:]) {
+				t.Errorf("%s: formatting %q changed the rest of the file", name, raw[b[0]:b[1]])
+			}
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package mdtool
+
+import (
+	"bytes"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// Neither formatter can round-trip footnotes using the parser alone.
+// Without the footnote extension, definitions become paragraphs and a
+// second paragraph becomes a code block.  With it, blackfriday moves
+// every definition to the end of the document (and v2 duplicates
+// text of notes that are referenced twice).
+//
+// Instead, definitions are cut out of the source before parsing and
+// replaced with a placeholder paragraph, in a block quote if the
+// definition was in one.  Each definition body is formatted on its own
+// and put back in place of the placeholder.  References are left in the
+// text where they render as-is.
+
+var (
+	footnoteDef = regexp.MustCompile(`^ {0,3}\[\^([^\]\s]+)\]:[ \t]?`)
+	footnoteRef = regexp.MustCompile(`\[\^([^\]\s]+)\]`)
+	quotePrefix = regexp.MustCompile(`^(?: {0,3}> ?)+`)
+)
+
+// footnote is a footnote definition cut from the source
+type footnote struct {
+	name string
+	body []byte
+}
+
+func footnotePlaceholder(i int) string {
+	return placeholder("FOOTNOTE", i)
+}
+
+// extractFootnotes removes footnote definitions from the source,
+// replacing each with a placeholder paragraph.  If renumber is true the
+// definitions are removed without a placeholder, and all footnotes are
+// renamed 1, 2, 3... in order of first reference.
+func extractFootnotes(src []byte, renumber bool) ([]byte, []footnote) {
+	var notes []footnote
+	code := fencedCode(src)
+	out := bytes.Buffer{}
+	for offset := 0; offset < len(src); {
+		line := nextLine(src, offset)
+		if end := inRange(code, offset); end != -1 {
+			out.Write(src[offset:end])
+			offset = end
+			continue
+		}
+		quote := quotePrefix.Find(line)
+		m := footnoteDef.FindSubmatch(line[len(quote):])
+		if m == nil {
+			out.Write(line)
+			offset += len(line)
+			continue
+		}
+
+		// first line, then any continuation lines
+		body := bytes.Buffer{}
+		body.Write(line[len(quote)+len(m[0]):])
+		offset += len(line)
+		blanks := 0 // blank lines that may or may not be part of the note
+		blankBytes := 0
+	loop:
+		for offset < len(src) {
+			line = nextLine(src, offset)
+			size := len(line)
+			if len(quote) != 0 {
+				// the note ends with the block quote
+				if quoteDepth(line) != quoteDepth(quote) {
+					break
+				}
+				line = line[len(quotePrefix.Find(line)):]
+			}
+			if len(bytes.TrimSpace(line)) == 0 {
+				blanks++
+				blankBytes += size
+				offset += size
+				continue
+			}
+			switch {
+			case footnoteDef.Match(line):
+				break loop
+			case bytes.HasPrefix(line, []byte(indent1)):
+				line = line[len(indent1):]
+			case line[0] == '\t':
+				line = line[1:]
+			case blanks != 0:
+				// text that isn't indented after a blank line
+				// ends the note
+				break loop
+			}
+			body.Write(bytes.Repeat([]byte{'\n'}, blanks))
+			body.Write(line)
+			blanks, blankBytes = 0, 0
+			offset += size
+		}
+		// give back trailing blank lines
+		offset -= blankBytes
+		if !renumber {
+			blank := string(bytes.TrimRight(quote, " "))
+			out.WriteString(blank + "\n" + string(quote) + footnotePlaceholder(len(notes)) + "\n" + blank + "\n")
+		}
+		notes = append(notes, footnote{
+			name: string(m[1]),
+			body: bytes.TrimRight(body.Bytes(), " \t\n"),
+		})
+	}
+	if !renumber {
+		return out.Bytes(), notes
+	}
+	return renumberFootnotes(out.Bytes(), notes)
+}
+
+// renumberFootnotes renames footnotes by order of first reference, and
+// sorts the definitions to match.  References in code are left alone.
+func renumberFootnotes(src []byte, notes []footnote) ([]byte, []footnote) {
+	names := make(map[string]string)
+	addRefs := func(text []byte) []byte {
+		for _, m := range footnoteRef.FindAllSubmatch(text, -1) {
+			if _, ok := names[string(m[1])]; !ok {
+				names[string(m[1])] = strconv.Itoa(len(names) + 1)
+			}
+		}
+		return text
+	}
+	outsideCode(src, addRefs)
+	for _, n := range notes {
+		outsideCode(n.body, addRefs)
+	}
+	for _, n := range notes {
+		if _, ok := names[n.name]; !ok {
+			names[n.name] = strconv.Itoa(len(names) + 1)
+		}
+	}
+	rename := func(text []byte) []byte {
+		return footnoteRef.ReplaceAllFunc(text, func(ref []byte) []byte {
+			name := string(ref[2 : len(ref)-1])
+			return []byte("[^" + names[name] + "]")
+		})
+	}
+
+	out := outsideCode(src, rename)
+	for i := range notes {
+		notes[i].name = names[notes[i].name]
+		notes[i].body = outsideCode(notes[i].body, rename)
+	}
+	sort.SliceStable(notes, func(i, j int) bool {
+		a, _ := strconv.Atoi(notes[i].name)
+		b, _ := strconv.Atoi(notes[j].name)
+		return a < b
+	})
+	return out, notes
+}
+
+// outsideCode replaces the text outside of fenced code and code spans
+// with fn of it
+func outsideCode(text []byte, fn func([]byte) []byte) []byte {
+	out := bytes.Buffer{}
+	last := 0
+	for _, r := range fencedCode(text) {
+		out.Write(outsideCodeSpans(text[last:r[0]], fn))
+		out.Write(text[r[0]:r[1]])
+		last = r[1]
+	}
+	out.Write(outsideCodeSpans(text[last:], fn))
+	return out.Bytes()
+}
+
+func outsideCodeSpans(text []byte, fn func([]byte) []byte) []byte {
+	out := bytes.Buffer{}
+	last := 0
+	for _, r := range codeSpans(text) {
+		out.Write(fn(text[last:r[0]]))
+		out.Write(text[r[0]:r[1]])
+		last = r[1]
+	}
+	out.Write(fn(text[last:]))
+	return out.Bytes()
+}
+
+// noteOptions returns the options for formatting the text of a
+// footnote, which is renumbered with the document if at all
+func noteOptions(opt *FmtOptions) *FmtOptions {
+	if opt == nil || !opt.FootnoteRenumber {
+		return opt
+	}
+	noteOpt := *opt
+	noteOpt.FootnoteRenumber = false
+	return &noteOpt
+}
+
+// restoreFootnotes formats each footnote body and puts it back in place
+// of the placeholder.  If renumber is true, all the definitions are
+// added to the end of the document instead.
+func restoreFootnotes(out []byte, notes []footnote, renumber bool, format func([]byte) []byte) []byte {
+	if len(notes) == 0 {
+		return out
+	}
+	defs := make([][]byte, len(notes))
+	for i, n := range notes {
+		body := bytes.TrimSpace(format(n.body))
+		def := bytes.Buffer{}
+		def.WriteString("[^" + n.name + "]: ")
+		for j, line := range bytes.Split(body, []byte{'\n'}) {
+			if j != 0 {
+				def.WriteByte('\n')
+				if len(line) != 0 {
+					def.WriteString(indent1)
+				}
+			}
+			def.Write(line)
+		}
+		defs[i] = def.Bytes()
+	}
+
+	if renumber {
+		out = bytes.TrimRight(out, "\n")
+		if len(out) != 0 {
+			out = append(out, '\n', '\n')
+		}
+		out = append(out, bytes.Join(defs, []byte("\n\n"))...)
+		return append(out, '\n')
+	}
+
+	for i, def := range defs {
+		// the lines of a note in a block quote continue the quote
+		placeholder := []byte(footnotePlaceholder(i))
+		at := bytes.Index(out, placeholder)
+		if at == -1 {
+			continue
+		}
+		start := bytes.LastIndexByte(out[:at], '\n') + 1
+		def = prefixLines(def, "", string(out[start:at]))
+		out = bytes.Replace(out, placeholder, def, 1)
+	}
+	return out
+}
+
+// nextLine returns the line starting at offset, including the newline
+func nextLine(src []byte, offset int) []byte {
+	line := src[offset:]
+	if i := bytes.IndexByte(line, '\n'); i != -1 {
+		line = line[:i+1]
+	}
+	return line
+}
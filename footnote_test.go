@@ -0,0 +1,79 @@
+package mdtool
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFootnotes(t *testing.T) {
+	src := "Text[^b] and [^a] and [^b].\n\n[^a]: Note A\n\nMiddle.\n\n[^b]: Note B.\n\n    second para\n\nEnd.\n"
+	cases := []struct {
+		renumber bool
+		want     string
+	}{
+		{false, "Text[^b] and [^a] and [^b].\n\n[^a]: Note A\n\nMiddle.\n\n" +
+			"[^b]: Note B.\n\n    second para\n\nEnd."},
+		{true, "Text[^1] and [^2] and [^1].\n\nMiddle.\n\nEnd.\n\n" +
+			"[^1]: Note B.\n\n    second para\n\n[^2]: Note A"},
+	}
+	formatters := map[string]func([]byte, *FmtOptions) []byte{
+		"Fmt":  Fmt,
+		"Fmt2": Fmt2,
+	}
+	for name, format := range formatters {
+		for idx, tt := range cases {
+			opt := &FmtOptions{LineLength: 70, FootnoteRenumber: tt.renumber}
+			got := strings.TrimSpace(string(format([]byte(src), opt)))
+			if got != tt.want {
+				t.Errorf("%s case %d)\n%s\n---\n%s", name, idx, tt.want, got)
+			}
+		}
+	}
+}
+
+func TestFootnoteInNote(t *testing.T) {
+	src := "A[^x] B[^y].\n\n[^x]: see also[^y]\n\n[^y]: why\n"
+	want := "A[^1] B[^2].\n\n[^1]: see also[^2]\n\n[^2]: why"
+	for name, format := range map[string]func([]byte, *FmtOptions) []byte{"Fmt": Fmt, "Fmt2": Fmt2} {
+		got := strings.TrimSpace(string(format([]byte(src), &FmtOptions{FootnoteRenumber: true})))
+		if got != want {
+			t.Errorf("%s)\n%s\n---\n%s", name, want, got)
+		}
+	}
+}
+
+func TestFootnotesInContainers(t *testing.T) {
+	cases := []struct {
+		src      string
+		renumber bool
+		want     string
+		fmt2Only bool // Fmt starts a block quote with a blank line
+	}{
+		{"Text[^b] and `[^x]`.\n\n[^b]: Note `[^y]` B.\n", true,
+			"Text[^1] and `[^x]`.\n\n[^1]: Note `[^y]` B.", false},
+		{"Text[^b] and ``a`[^x]``.\n\n[^b]: Note B.\n", true,
+			"Text[^1] and ``a`[^x]``.\n\n[^1]: Note B.", false},
+		{"Text[^q].\n\n> Quote.\n>\n> [^q]: Note Q.\n", false,
+			"Text[^q].\n\n> Quote.\n>\n> [^q]: Note Q.", true},
+		{"Text[^q].\n\n> [^q]: Note Q.\n>\n>     second para\n\nEnd.\n", false,
+			"Text[^q].\n\n> [^q]: Note Q.\n>\n>     second para\n\nEnd.", true},
+		{"Text[^q].\n\n> [^q]: Note Q.\n", true,
+			"Text[^1].\n\n[^1]: Note Q.", false},
+	}
+	formatters := map[string]func([]byte, *FmtOptions) []byte{
+		"Fmt":  Fmt,
+		"Fmt2": Fmt2,
+	}
+	for name, format := range formatters {
+		for idx, tt := range cases {
+			if tt.fmt2Only && name != "Fmt2" {
+				continue
+			}
+			opt := &FmtOptions{LineLength: 70, FootnoteRenumber: tt.renumber}
+			got := strings.TrimSpace(string(format([]byte(tt.src), opt)))
+			if got != tt.want {
+				t.Errorf("%s case %d)\n%q\n%q", name, idx, tt.want, got)
+			}
+		}
+	}
+}
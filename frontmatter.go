@@ -0,0 +1,349 @@
+package mdtool
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FrontMatter is the metadata at the start of a page used by static
+// site generators such as Hugo and Jekyll: YAML between --- lines,
+// TOML between +++ lines, or a JSON object.  Markdown parsers don't
+// know about it, and would read YAML as a thematic break followed by
+// a paragraph or setext heading, so it is split off before parsing.
+type FrontMatter struct {
+	// Format is "yaml", "toml" or "json"
+	Format string
+
+	// Raw is the front matter as written, including the delimiters
+	// and the newline after the last one.
+	Raw []byte
+}
+
+// SplitFrontMatter returns the front matter at the start of src and
+// the rest of the document, or nil and src if there isn't any.
+func SplitFrontMatter(src []byte) (*FrontMatter, []byte) {
+	first := nextLine(src, 0)
+	var format, open, close string
+	switch string(bytes.TrimRight(first, " \t\r\n")) {
+	case "---":
+		format, open, close = "yaml", "---", "..."
+	case "+++":
+		format, open, close = "toml", "+++", "+++"
+	case "{":
+		format, open, close = "json", "}", "}"
+	default:
+		return nil, src
+	}
+	if !bytes.HasSuffix(first, []byte{'\n'}) {
+		return nil, src
+	}
+	for offset := len(first); offset < len(src); {
+		line := nextLine(src, offset)
+		offset += len(line)
+		text := string(bytes.TrimRight(line, " \t\r\n"))
+		if text == open || text == close {
+			return &FrontMatter{Format: format, Raw: src[:offset]}, src[offset:]
+		}
+	}
+	// never closed, so not front matter
+	return nil, src
+}
+
+// Content returns the front matter without the delimiters.  For JSON
+// the braces are part of the content.
+func (fm *FrontMatter) Content() []byte {
+	if fm.Format == "json" {
+		return fm.Raw
+	}
+	content := fm.Raw[len(nextLine(fm.Raw, 0)):]
+	lines := splitLines(content)
+	return content[:len(content)-len(lines[len(lines)-1])]
+}
+
+// Values parses the front matter, see parseYAML and parseTOML.
+func (fm *FrontMatter) Values() (map[string]interface{}, error) {
+	switch fm.Format {
+	case "yaml":
+		return parseYAML(fm.Content())
+	case "toml":
+		return parseTOML(fm.Content())
+	case "json":
+		return parseJSON(fm.Content())
+	}
+	return nil, fmt.Errorf("unknown front matter format %q", fm.Format)
+}
+
+// Normalize rewrites the front matter in a format, or in the same
+// format if it is "", with the keys sorted and every string quoted.
+// Comments are not kept.
+func (fm *FrontMatter) Normalize(format string) ([]byte, error) {
+	values, err := fm.Values()
+	if err != nil {
+		return nil, err
+	}
+	if format == "" {
+		format = fm.Format
+	}
+	out := bytes.Buffer{}
+	switch format {
+	case "yaml":
+		out.WriteString("---\n")
+		writeYAML(&out, values, "")
+		out.WriteString("---\n")
+	case "toml":
+		if key := tomlNull(values, ""); key != "" {
+			return nil, fmt.Errorf("TOML has no null, for %s", key)
+		}
+		out.WriteString("+++\n")
+		writeTOML(&out, values, "")
+		out.WriteString("+++\n")
+	case "json":
+		enc := json.NewEncoder(&out)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(values); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown front matter format %q", format)
+	}
+	return out.Bytes(), nil
+}
+
+// joinFrontMatter puts front matter back in front of the formatted
+// body, normalized as set by FmtOptions.FrontMatterFormat.  It is kept
+// as written if it can't be parsed, see FrontMatterError.
+func joinFrontMatter(fm *FrontMatter, body, formatted []byte, opt *FmtOptions) []byte {
+	if fm == nil {
+		return formatted
+	}
+	out := bytes.Buffer{}
+	if normalized, err := normalizeFrontMatter(fm, opt); err == nil && normalized != nil {
+		out.Write(normalized)
+	} else {
+		out.Write(fm.Raw)
+	}
+	formatted = bytes.TrimLeft(formatted, "\n")
+	if len(formatted) == 0 {
+		return out.Bytes()
+	}
+	// keep a blank line after the front matter if there was one
+	if len(body) != 0 && len(bytes.TrimSpace(nextLine(body, 0))) == 0 {
+		out.WriteByte('\n')
+	}
+	out.Write(formatted)
+	return out.Bytes()
+}
+
+// normalizeFrontMatter rewrites the front matter as set by
+// FmtOptions.FrontMatterFormat, or returns nil if it is kept.
+func normalizeFrontMatter(fm *FrontMatter, opt *FmtOptions) ([]byte, error) {
+	if opt == nil || opt.FrontMatterFormat == "" || opt.FrontMatterFormat == "keep" {
+		return nil, nil
+	}
+	format := opt.FrontMatterFormat
+	if format == "normalize" {
+		format = ""
+	}
+	return fm.Normalize(format)
+}
+
+// FrontMatterError returns why the front matter of src can't be
+// rewritten as set by FmtOptions.FrontMatterFormat, or nil.  Fmt and
+// Fmt2 keep such front matter as written.
+func FrontMatterError(src []byte, opt *FmtOptions) error {
+	_, src = splitEncoding(src)
+	fm, _ := SplitFrontMatter(src)
+	if fm == nil {
+		return nil
+	}
+	_, err := normalizeFrontMatter(fm, opt)
+	return err
+}
+
+// parseJSON parses a JSON object into the same form as parseYAML, with
+// whole numbers as int.
+func parseJSON(src []byte) (map[string]interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(src))
+	dec.UseNumber()
+	var m map[string]interface{}
+	if err := dec.Decode(&m); err != nil {
+		return nil, err
+	}
+	return jsonValue(m).(map[string]interface{}), nil
+}
+
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if n, err := strconv.Atoi(v.String()); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case []interface{}:
+		for i := range v {
+			v[i] = jsonValue(v[i])
+		}
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = jsonValue(v[k])
+		}
+	}
+	return v
+}
+
+// tomlNull returns the key of the first null value, which TOML can't
+// write, or "" if there isn't one
+func tomlNull(v interface{}, key string) string {
+	switch v := v.(type) {
+	case nil:
+		return key
+	case map[string]interface{}:
+		for _, k := range sortedKeys(v) {
+			name := k
+			if key != "" {
+				name = key + "." + k
+			}
+			if null := tomlNull(v[k], name); null != "" {
+				return null
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			if null := tomlNull(item, fmt.Sprintf("%s[%d]", key, i)); null != "" {
+				return null
+			}
+		}
+	}
+	return ""
+}
+
+// writeTOML writes a table's values, and then its sub-tables and arrays
+// of tables, with keys sorted.  The values must not be null, see
+// tomlNull.
+func writeTOML(buf *bytes.Buffer, m map[string]interface{}, table string) {
+	keys := sortedKeys(m)
+	for _, k := range keys {
+		if isTOMLValue(m[k]) {
+			buf.WriteString(tomlKey(k) + " = " + tomlValue(m[k]) + "\n")
+		}
+	}
+	for _, k := range keys {
+		name := tomlKey(k)
+		if table != "" {
+			name = table + "." + name
+		}
+		switch v := m[k].(type) {
+		case map[string]interface{}:
+			// a table holding only tables doesn't need a header
+			if len(v) == 0 || hasTOMLValues(v) {
+				if buf.Len() != 0 {
+					buf.WriteByte('\n')
+				}
+				buf.WriteString("[" + name + "]\n")
+			}
+			writeTOML(buf, v, name)
+		case []interface{}:
+			if !isTableArray(v) {
+				continue
+			}
+			for _, item := range v {
+				if buf.Len() != 0 {
+					buf.WriteByte('\n')
+				}
+				buf.WriteString("[[" + name + "]]\n")
+				writeTOML(buf, item.(map[string]interface{}), name)
+			}
+		}
+	}
+}
+
+// hasTOMLValues is true if a table has values written as key = value
+func hasTOMLValues(m map[string]interface{}) bool {
+	for _, v := range m {
+		if isTOMLValue(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTOMLValue is false for values written as tables, and nil
+func isTOMLValue(v interface{}) bool {
+	switch v := v.(type) {
+	case nil, map[string]interface{}:
+		return false
+	case []interface{}:
+		return !isTableArray(v)
+	}
+	return true
+}
+
+// isTableArray is true for a list of tables, written as [[name]]
+func isTableArray(list []interface{}) bool {
+	for _, item := range list {
+		if _, ok := item.(map[string]interface{}); !ok {
+			return false
+		}
+	}
+	return len(list) != 0
+}
+
+func tomlValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return strconv.Quote(v)
+	case float64:
+		return formatFloat(v)
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = tomlValue(item)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case map[string]interface{}:
+		items := []string{}
+		for _, k := range sortedKeys(v) {
+			if v[k] != nil {
+				items = append(items, tomlKey(k)+" = "+tomlValue(v[k]))
+			}
+		}
+		return "{" + strings.Join(items, ", ") + "}"
+	}
+	return fmt.Sprint(v)
+}
+
+var bareKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// tomlKey quotes a key unless it is a bare key.  The same keys are
+// safe in YAML.
+func tomlKey(k string) string {
+	if bareKey.MatchString(k) {
+		return k
+	}
+	return strconv.Quote(k)
+}
+
+// formatFloat writes a float so it reads back as one, e.g. 1.0 not 1
+func formatFloat(f float64) string {
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eEnN") {
+		s += ".0"
+	}
+	return s
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
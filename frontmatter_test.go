@@ -0,0 +1,103 @@
+package mdtool
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitFrontMatter(t *testing.T) {
+	cases := []struct {
+		src    string
+		format string
+		rest   string
+	}{
+		{"---\ntitle: x\n---\n\n# Title\n", "yaml", "\n# Title\n"},
+		{"---\ntitle: x\n...\nbody\n", "yaml", "body\n"},
+		{"---\r\ntitle: x\r\n---\r\nbody\r\n", "yaml", "body\r\n"},
+		{"+++\ntitle = \"x\"\n+++\nbody\n", "toml", "body\n"},
+		{"{\n  \"title\": \"x\"\n}\nbody\n", "json", "body\n"},
+		{"---\ntitle: x\n", "", "---\ntitle: x\n"},
+		{"text\n\n---\ntitle: x\n---\n", "", "text\n\n---\ntitle: x\n---\n"},
+		{"---", "", "---"},
+	}
+	for idx, tt := range cases {
+		fm, rest := SplitFrontMatter([]byte(tt.src))
+		format := ""
+		if fm != nil {
+			format = fm.Format
+			if string(fm.Raw)+string(rest) != tt.src {
+				t.Errorf("Case %d) %q + %q is not the source", idx, fm.Raw, rest)
+			}
+		}
+		if format != tt.format || string(rest) != tt.rest {
+			t.Errorf("Case %d) got %q %q, want %q %q", idx, format, rest, tt.format, tt.rest)
+		}
+	}
+}
+
+func TestFmtFrontMatter(t *testing.T) {
+	yaml := "---\ntitle:   My Page\ndate: 2018-06-01\nweight: 1.0\ndraft: false\ntags: [b, 'a']\nmenu:\n  main:\n    parent: docs\n---\n\nSome    text.\n"
+	cases := []struct {
+		src    string
+		format string
+		want   string
+	}{
+		{yaml, "", "---\ntitle:   My Page\ndate: 2018-06-01\nweight: 1.0\ndraft: false\ntags: [b, 'a']\nmenu:\n  main:\n    parent: docs\n---\n\nSome text.\n"},
+		{yaml, "normalize", "---\ndate: 2018-06-01\ndraft: false\nmenu:\n  main:\n    parent: \"docs\"\ntags:\n  - \"b\"\n  - \"a\"\ntitle: \"My Page\"\nweight: 1.0\n---\n\nSome text.\n"},
+		{yaml, "toml", "+++\ndate = 2018-06-01\ndraft = false\ntags = [\"b\", \"a\"]\ntitle = \"My Page\"\nweight = 1.0\n\n[menu.main]\nparent = \"docs\"\n+++\n\nSome text.\n"},
+		{yaml, "json", "{\n  \"date\": \"2018-06-01\",\n  \"draft\": false,\n  \"menu\": {\n    \"main\": {\n      \"parent\": \"docs\"\n    }\n  },\n  \"tags\": [\n    \"b\",\n    \"a\"\n  ],\n  \"title\": \"My Page\",\n  \"weight\": 1\n}\n\nSome text.\n"},
+		{"+++\n[[resources]]\nsrc = \"a.png\"\n\n[[resources]]\nsrc = \"b.png\"\n+++\ntext\n", "yaml", "---\nresources:\n  - src: \"a.png\"\n  - src: \"b.png\"\n---\ntext\n"},
+		{"{\n\"b\": 2, \"a\": \"<x>\"\n}\n", "normalize", "{\n  \"a\": \"<x>\",\n  \"b\": 2\n}\n"},
+		{"+++\ndate = 2018-06-01\nat = 1979-05-27T07:32:00-07:00\n+++\n", "yaml", "---\nat: 1979-05-27T07:32:00-07:00\ndate: 2018-06-01\n---\n"},
+		{"---\ndate: \"2018-06-01\"\n---\n", "toml", "+++\ndate = \"2018-06-01\"\n+++\n"},
+		{"---\ndescription: >\n  folded\n---\n", "toml", "+++\ndescription = \"folded\\n\"\n+++\n"},
+
+		// can't be parsed, so kept as written
+		{"---\ntags: [a\n---\n", "toml", "---\ntags: [a\n---\n"},
+		// TOML has no null
+		{"---\nempty:\n---\n", "toml", "---\nempty:\n---\n"},
+		{"---\nparams: {y: null}\n---\n", "toml", "---\nparams: {y: null}\n---\n"},
+	}
+	for idx, tt := range cases {
+		opt := &FmtOptions{LineLength: 70, FrontMatterFormat: tt.format}
+		for _, format := range []func([]byte, *FmtOptions) []byte{Fmt, Fmt2} {
+			got := strings.TrimRight(string(format([]byte(tt.src), opt)), "\n") + "\n"
+			if got != tt.want {
+				t.Errorf("Case %d)\n%q\n%q", idx, tt.want, got)
+			}
+		}
+	}
+}
+
+func TestAstFrontMatter(t *testing.T) {
+	node := Ast([]byte("+++\ntitle = \"x\"\n+++\n# Title\n"))
+	if len(node.Children) != 2 || node.Children[0].Type != "FrontMatter" || node.Children[1].Type != "Heading" {
+		t.Fatalf("expected FrontMatter and Heading, got %+v", node.Children)
+	}
+	if node.Children[0].Literal != "+++\ntitle = \"x\"\n+++\n" {
+		t.Errorf("got literal %q", node.Children[0].Literal)
+	}
+}
+
+func TestFrontMatterError(t *testing.T) {
+	cases := []struct {
+		src    string
+		format string
+		bad    bool
+	}{
+		{"---\ntags: [a\n---\ntext\n", "toml", true},
+		{"---\ntags: [a\n---\ntext\n", "keep", false},
+		{"---\ntags: [a]\n---\ntext\n", "toml", false},
+		{"---\nempty:\n---\ntext\n", "toml", true},
+		{"---\nparams: {y: null}\n---\ntext\n", "toml", true},
+		{"---\ntags: [a, ~]\n---\ntext\n", "toml", true},
+		{"---\nempty:\n---\ntext\n", "json", false},
+		{"text\n", "toml", false},
+	}
+	for idx, tt := range cases {
+		err := FrontMatterError([]byte(tt.src), &FmtOptions{FrontMatterFormat: tt.format})
+		if (err != nil) != tt.bad {
+			t.Errorf("Case %d) got %v", idx, err)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package mdtool
+
+import (
+	"bytes"
+	"sort"
+)
+
+// The parsers and formatters only handle \n line endings.  A document
+// with \r\n line endings, or a UTF-8 byte order mark, is converted
+// before it is formatted or vetted, and formatted output is converted
+// back, see FmtOptions.LineEnding.
+
+var (
+	byteOrderMark = []byte("\xef\xbb\xbf")
+	crlf          = []byte("\r\n")
+	lf            = []byte("\n")
+)
+
+// textEncoding is the byte order mark and line ending of a document
+type textEncoding struct {
+	bom  bool
+	crlf bool
+}
+
+// splitEncoding returns the encoding of src, and src without a byte
+// order mark and with \n line endings.  If the line endings are mixed
+// the most common one is used.
+func splitEncoding(src []byte) (textEncoding, []byte) {
+	enc := textEncoding{}
+	if bytes.HasPrefix(src, byteOrderMark) {
+		enc.bom = true
+		src = src[len(byteOrderMark):]
+	}
+	n := bytes.Count(src, crlf)
+	if n == 0 {
+		return enc, src
+	}
+	enc.crlf = n >= bytes.Count(src, lf)-n
+	return enc, bytes.Replace(src, crlf, lf, -1)
+}
+
+// join returns text with \n line endings in the encoding.  lineEnding
+// is "lf" or "crlf" to use that line ending instead of the original.
+func (enc textEncoding) join(text []byte, lineEnding string) []byte {
+	useCRLF := enc.crlf
+	switch lineEnding {
+	case "lf":
+		useCRLF = false
+	case "crlf":
+		useCRLF = true
+	}
+	if useCRLF {
+		// footnotes may have been formatted with \r\n already
+		text = bytes.Replace(bytes.Replace(text, crlf, lf, -1), lf, crlf, -1)
+	}
+	if enc.bom {
+		text = append(append([]byte{}, byteOrderMark...), text...)
+	}
+	return text
+}
+
+// lineEnding returns the line ending for formatted output, "" to keep
+// the input's
+func lineEnding(opt *FmtOptions) string {
+	if opt == nil {
+		return ""
+	}
+	return opt.LineEnding
+}
+
+// offsetMap maps offsets in text from splitEncoding back to offsets in
+// the original
+type offsetMap struct {
+	bom int   // length of any byte order mark
+	crs []int // offsets in the text of each \n that followed a \r
+}
+
+func newOffsetMap(raw []byte) offsetMap {
+	m := offsetMap{}
+	if bytes.HasPrefix(raw, byteOrderMark) {
+		m.bom = len(byteOrderMark)
+	}
+	removed := 0
+	for i := m.bom; i < len(raw); i++ {
+		if raw[i] == '\r' && i+1 < len(raw) && raw[i+1] == '\n' {
+			m.crs = append(m.crs, i-m.bom-removed)
+			removed++
+		}
+	}
+	return m
+}
+
+// raw returns the original offset.  An offset at a \n that followed a
+// \r is at the \r.
+func (m offsetMap) raw(offset int) int {
+	return offset + m.bom + sort.SearchInts(m.crs, offset)
+}
+
+// mixedLineEndings finds the first line whose ending differs from the
+// first line's.
+func mixedLineEndings(raw []byte, faults []Fault) []Fault {
+	first := -1 // 1 for \r\n, 0 for \n
+	for offset := 0; offset < len(raw); {
+		i := bytes.IndexByte(raw[offset:], '\n')
+		if i == -1 {
+			break
+		}
+		end := offset + i
+		ending := 0
+		if end > 0 && raw[end-1] == '\r' {
+			ending = 1
+		}
+		switch {
+		case first == -1:
+			first = ending
+		case ending != first:
+			return append(faults, Fault{
+				Offset: end - ending,
+				Reason: FaultMixedLineEndings,
+			})
+		}
+		offset = end + 1
+	}
+	return faults
+}
@@ -0,0 +1,35 @@
+package mdtool
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFmtLineEndings(t *testing.T) {
+	cases := []struct {
+		src    string
+		ending string
+		want   string
+	}{
+		{"# h1\r\n\r\ntext\r\nmore\r\n", "", "# h1\r\n\r\ntext more\r\n"},
+		{"# h1\n\ntext\nmore\n", "", "# h1\n\ntext more\n"},
+		{"# h1\r\n\r\ntext\nmore\r\n", "", "# h1\r\n\r\ntext more\r\n"},
+		{"# h1\r\n\r\ntext\r\n", "lf", "# h1\n\ntext\n"},
+		{"# h1\n\ntext\n", "crlf", "# h1\r\n\r\ntext\r\n"},
+		{"\xef\xbb\xbf# h1\r\n\r\ntext\r\n", "", "\xef\xbb\xbf# h1\r\n\r\ntext\r\n"},
+		{"\xef\xbb\xbf# h1\r\n", "lf", "\xef\xbb\xbf# h1\n"},
+		{"---\r\ntitle: x\r\n---\r\n\r\ntext\r\n", "", "---\r\ntitle: x\r\n---\r\n\r\ntext\r\n"},
+		{"```go\r\nx:=1\r\n```\r\n", "", "```go\r\nx := 1\r\n```\r\n"},
+		{"a[^1]\n\n[^1]: note\n", "crlf", "a[^1]\r\n\r\n[^1]: note\r\n"},
+	}
+	for idx, tt := range cases {
+		opt := &FmtOptions{LineLength: 70, HeadingStyle: "atx", LineEnding: tt.ending}
+		for _, format := range []func([]byte, *FmtOptions) []byte{Fmt, Fmt2} {
+			// Fmt starts with a blank line
+			got := strings.TrimLeft(string(format([]byte(tt.src), opt)), "\r\n")
+			if got != tt.want {
+				t.Errorf("Case %d)\n%q\n%q", idx, tt.want, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package mdtool
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Blackfriday resolves reference links while parsing, so the AST
+// can't tell [text][ref] from [text](url).  Like footnotes, reference
+// definitions are cut out of the source before parsing and put back
+// afterwards, in the block quote or list item they were in.  Without a
+// definition [text][ref] is just text, and is written out unchanged.
+//
+// A title runs to the last quote on the line, and blackfriday keeps any
+// backslashes in it.
+
+var (
+	linkRefDef   = regexp.MustCompile(`^ {0,3}\[([^\]^][^\]]*)\]:[ \t]*(\S+)(?:[ \t]+(".*"|'.*'|\(.*\)))?[ \t]*\n?$`)
+	linkRefTitle = regexp.MustCompile(`^[ \t]+(".*"|'.*'|\(.*\))[ \t]*\n?$`)
+)
+
+// linkRef is a link reference definition, e.g. [label]: url "title"
+type linkRef struct {
+	label string
+	dest  []byte
+	title []byte
+}
+
+func (r linkRef) String() string {
+	s := "[" + r.label + "]: " + linkDestination(r.dest)
+	if len(r.title) != 0 {
+		s += " " + linkTitle(r.title, true)
+	}
+	return s
+}
+
+func linkRefPlaceholder(i int) string {
+	return placeholder("LINKREF", i)
+}
+
+// extractLinkRefs removes link reference definitions from the source.
+// Each run of definitions is replaced with a placeholder paragraph.
+func extractLinkRefs(src []byte) ([]byte, [][]linkRef) {
+	var groups [][]linkRef
+	var group []linkRef
+	var prefix []byte // the container of the group
+	code := fencedCode(src)
+	out := bytes.Buffer{}
+	flush := func() {
+		if group != nil {
+			// a second blank line would end a list
+			blank := string(bytes.TrimRight(prefix, " "))
+			if !endsWithBlankLine(out.Bytes()) {
+				out.WriteString(blank + "\n")
+			}
+			out.WriteString(string(prefix) + linkRefPlaceholder(len(groups)) + "\n" + blank + "\n")
+			groups = append(groups, group)
+			group = nil
+		}
+	}
+	for offset := 0; offset < len(src); {
+		line := nextLine(src, offset)
+		if end := inRange(code, offset); end != -1 {
+			flush()
+			out.Write(src[offset:end])
+			offset = end
+			continue
+		}
+		container := linkRefContainer(src, offset)
+		var m [][]byte
+		if container != nil {
+			m = linkRefDef.FindSubmatch(line[len(container):])
+		}
+		if m != nil && group != nil && !bytes.Equal(container, prefix) {
+			flush()
+		}
+		if m == nil {
+			if !isBlankLine(line) || group == nil || quoteDepth(line) != quoteDepth(prefix) {
+				flush()
+				out.Write(line)
+			}
+			offset += len(line)
+			continue
+		}
+		prefix = container
+		offset += len(line)
+		ref := linkRef{
+			label: string(m[1]),
+			dest:  bytes.Trim(m[2], "<>"),
+		}
+		title := m[3]
+		if title == nil && offset < len(src) {
+			// title can be on the next line
+			next := nextLine(src, offset)
+			if quoteDepth(next) == quoteDepth(container) {
+				if t := linkRefTitle.FindSubmatch(next[len(quotePrefix.Find(next)):]); t != nil {
+					title = t[1]
+					offset += len(next)
+				}
+			}
+		}
+		if len(title) >= 2 {
+			ref.title = title[1 : len(title)-1]
+		}
+		group = append(group, ref)
+	}
+	flush()
+	return out.Bytes(), groups
+}
+
+// linkRefContainer returns the block quote markers and list item indent
+// before a definition on the line at offset, empty at the top level, or
+// nil where blackfriday doesn't read one.  A list item goes on at the
+// lines after it, and after a blank line at lines indented 4 more
+// spaces than its marker.  Blackfriday only reads definitions in an item
+// with a blank line.
+func linkRefContainer(src []byte, offset int) []byte {
+	line := nextLine(src, offset)
+	quote := line[:len(quotePrefix.Find(line))]
+	indent := len(line[len(quote):]) - len(bytes.TrimLeft(line[len(quote):], " "))
+	outside := quote
+	if indent > 3 {
+		// code
+		outside = nil
+	}
+
+	// the list item the line is in
+	blank := false
+	for i := offset; i > 0; {
+		start := bytes.LastIndexByte(src[:i-1], '\n') + 1
+		l := src[start:i]
+		i = start
+		if quoteDepth(l) != quoteDepth(quote) {
+			return outside
+		}
+		l = l[len(quotePrefix.Find(l)):]
+		text := bytes.TrimLeft(l, " ")
+		col := len(l) - len(text)
+		switch {
+		case len(bytes.TrimSpace(text)) == 0:
+			blank = true
+		case bulletItem.Match(text) || orderedItem.Match(text):
+			if col >= indent && blank {
+				continue
+			}
+			switch {
+			case !blank:
+				return nil
+			case indent-col < 4:
+				return outside
+			case indent-col > 7:
+				return nil
+			}
+			return line[:len(quote)+indent]
+		case col == 0 && blank:
+			return outside
+		}
+	}
+	return outside
+}
+
+// isBlankLine is true if the line is blank, or blank in a block quote
+func isBlankLine(line []byte) bool {
+	return len(bytes.TrimSpace(line[len(quotePrefix.Find(line)):])) == 0
+}
+
+// endsWithBlankLine is true if text is empty or its last line is blank
+func endsWithBlankLine(text []byte) bool {
+	if len(text) == 0 {
+		return true
+	}
+	text = text[:len(text)-1]
+	return isBlankLine(text[bytes.LastIndexByte(text, '\n')+1:])
+}
+
+// quoteDepth is the number of block quotes a line is in
+func quoteDepth(line []byte) int {
+	return bytes.Count(quotePrefix.Find(line), []byte{'>'})
+}
+
+// restoreLinkRefs puts the link reference definitions back in place
+// of the placeholders.
+func restoreLinkRefs(out []byte, groups [][]linkRef) []byte {
+	for i, group := range groups {
+		lines := make([]string, len(group))
+		for j, ref := range group {
+			lines[j] = ref.String()
+		}
+		// the lines of a group in a container continue it
+		placeholder := []byte(linkRefPlaceholder(i))
+		at := bytes.Index(out, placeholder)
+		if at == -1 {
+			continue
+		}
+		start := bytes.LastIndexByte(out[:at], '\n') + 1
+		if start == at && bytes.HasSuffix(out[:at], []byte("\n\n\n")) {
+			// one blank line is enough after a list
+			out = append(out[:at-1], out[at:]...)
+			at--
+			start--
+		}
+		refs := prefixLines([]byte(strings.Join(lines, "\n")), "", string(out[start:at]))
+		out = bytes.Replace(out, placeholder, refs, 1)
+	}
+	return out
+}
+
+// linkDestination writes a link URL, using <> if needed
+func linkDestination(dest []byte) string {
+	if bytes.ContainsAny(dest, " \t") {
+		return "<" + string(dest) + ">"
+	}
+	return string(dest)
+}
+
+// linkTitle quotes a link title, picking quotes that aren't in the
+// title.  A definition's title can also be in parentheses, but
+// blackfriday doesn't read them in an inline link.  Failing that, " is
+// escaped, unless it already is since blackfriday keeps backslashes.
+func linkTitle(title []byte, parens bool) string {
+	switch {
+	case !bytes.ContainsRune(title, '"'):
+		return `"` + string(title) + `"`
+	case !bytes.ContainsRune(title, '\''):
+		return `'` + string(title) + `'`
+	case parens && !bytes.ContainsAny(title, "()"):
+		return "(" + string(title) + ")"
+	}
+	out := []byte{'"'}
+	for i, c := range title {
+		if c == '"' && (i == 0 || title[i-1] != '\\') {
+			out = append(out, '\\')
+		}
+		out = append(out, c)
+	}
+	return string(append(out, '"'))
+}
+
+// linkRefs collects the definitions for links converted to
+// reference style.
+type linkRefs struct {
+	used    map[string]bool // labels already in the document
+	labels  map[string]string
+	pending []linkRef
+}
+
+func newLinkRefs(groups [][]linkRef) *linkRefs {
+	r := &linkRefs{
+		used:   make(map[string]bool),
+		labels: make(map[string]string),
+	}
+	for _, group := range groups {
+		for _, ref := range group {
+			r.used[strings.ToLower(ref.label)] = true
+		}
+	}
+	return r
+}
+
+// Label returns the label for a link, reusing the label of an earlier
+// link with the same destination and title.
+func (r *linkRefs) Label(dest []byte, title []byte) string {
+	key := string(dest) + "\x00" + string(title)
+	if label, ok := r.labels[key]; ok {
+		return label
+	}
+	n := len(r.labels) + 1
+	for r.used[strconv.Itoa(n)] {
+		n++
+	}
+	label := strconv.Itoa(n)
+	r.used[label] = true
+	r.labels[key] = label
+	r.pending = append(r.pending, linkRef{label: label, dest: dest, title: title})
+	return label
+}
+
+// Flush writes out any pending definitions as a block.
+// It returns true if anything was written.
+func (r *linkRefs) Flush(out *bytes.Buffer) bool {
+	if len(r.pending) == 0 {
+		return false
+	}
+	if out.Len() != 0 {
+		out.WriteString("\n\n")
+	}
+	for i, ref := range r.pending {
+		if i != 0 {
+			out.WriteByte('\n')
+		}
+		out.WriteString(ref.String())
+	}
+	r.pending = nil
+	return true
+}
@@ -0,0 +1,188 @@
+package mdtool
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Neither version of blackfriday keeps the numbers of an ordered list,
+// not even the start number, so they are read from the source.  Lists
+// are found in the order they start, which is the order the renderers
+// see them in, and each list has the numbers of its items as written.
+
+var (
+	orderedItem = regexp.MustCompile(`^(\d{1,9})[.)]([ \t]+|$)`)
+	bulletItem  = regexp.MustCompile(`^[-+*]([ \t]+|$)`)
+)
+
+// sourceList is a list being read by scanOrderedLists
+type sourceList struct {
+	quotes  int // block quote depth
+	col     int // column of the markers
+	content int // column of the text of the last item
+	index   int // index in the ordered lists, or -1 for a bullet list
+}
+
+// scanOrderedLists returns the numbers of each ordered list's items as
+// written, in the order the lists start.  It understands enough of
+// Markdown to find lists nested in lists and block quotes, and to skip
+// code.
+func scanOrderedLists(src []byte) [][]string {
+	var lists [][]string
+	var open []sourceList
+	fence := ""
+	blank := false
+	for offset := 0; offset < len(src); {
+		line := string(nextLine(src, offset))
+		offset += len(line)
+		text := strings.TrimRight(line, " \t\r\n")
+
+		// block quote markers
+		quotes := 0
+		for {
+			t := strings.TrimLeft(text, " ")
+			if !strings.HasPrefix(t, ">") || len(text)-len(t) > 3 {
+				break
+			}
+			quotes++
+			text = strings.TrimPrefix(t[1:], " ")
+		}
+		if fence != "" {
+			if strings.HasPrefix(strings.TrimLeft(text, " \t"), fence) {
+				fence = ""
+			}
+			continue
+		}
+		if strings.TrimSpace(text) == "" {
+			blank = true
+			continue
+		}
+		col := 0
+		for _, c := range text {
+			if c == ' ' {
+				col++
+			} else if c == '\t' {
+				col += 4 - col%4
+			} else {
+				break
+			}
+		}
+		rest := strings.TrimLeft(text, " \t")
+		for len(open) != 0 && open[len(open)-1].quotes > quotes {
+			open = open[:len(open)-1]
+		}
+
+		// indented code
+		top := -1
+		if len(open) != 0 && open[len(open)-1].quotes == quotes {
+			top = len(open) - 1
+		}
+		afterBlank := blank
+		blank = false
+		if (top == -1 && col >= 4) || (top != -1 && afterBlank && col >= open[top].content+4) {
+			continue
+		}
+
+		if strings.HasPrefix(rest, "```") || strings.HasPrefix(rest, "~~~") {
+			fence = rest[:3]
+			continue
+		}
+
+		m := orderedItem.FindStringSubmatch(rest)
+		ordered := m != nil
+		if m == nil {
+			m = bulletItem.FindStringSubmatch(rest)
+		}
+		if m == nil {
+			// text at or left of the text of an item, after a blank
+			// line, ends the list
+			for len(open) != 0 && open[len(open)-1].quotes == quotes && afterBlank && col < open[len(open)-1].content {
+				open = open[:len(open)-1]
+			}
+			continue
+		}
+		content := col + len(m[0])
+		if m[len(m)-1] == "" {
+			content++
+		}
+
+		// lists nested deeper than this item end
+		for len(open) != 0 && open[len(open)-1].quotes == quotes && col < open[len(open)-1].col-1 {
+			open = open[:len(open)-1]
+		}
+		if n := len(open); n != 0 && open[n-1].quotes == quotes && col <= open[n-1].col+1 {
+			l := &open[n-1]
+			if (l.index != -1) == ordered {
+				// the next item of the list
+				l.content = content
+				if ordered {
+					lists[l.index] = append(lists[l.index], m[1])
+				}
+				continue
+			}
+			// a different kind of list starts
+			open = open[:n-1]
+		}
+		index := -1
+		if ordered {
+			index = len(lists)
+			lists = append(lists, []string{m[1]})
+		}
+		open = append(open, sourceList{quotes: quotes, col: col, content: content, index: index})
+	}
+	return lists
+}
+
+// listNumbers numbers the items of an ordered list
+type listNumbers struct {
+	mode    string   // see FmtOptions.ListNumbering
+	written []string // the numbers as written, if known
+	count   int      // the number of items, if known
+	next    int
+}
+
+// Next returns the number of the next item
+func (l *listNumbers) Next() string {
+	l.next++
+	return l.number(l.next - 1)
+}
+
+// Width returns the width of the widest number, so the text of every
+// item can line up
+func (l *listNumbers) Width() int {
+	width := 0
+	for i := 0; i < l.count || i < l.next; i++ {
+		if w := len(l.number(i)); w > width {
+			width = w
+		}
+	}
+	return width
+}
+
+func (l *listNumbers) number(i int) string {
+	start := 1
+	if len(l.written) != 0 {
+		start, _ = strconv.Atoi(l.written[0])
+	}
+	switch l.mode {
+	case "one":
+		return strconv.Itoa(start)
+	case "preserve":
+		if i < len(l.written) {
+			return l.written[i]
+		}
+		if i != 0 {
+			n, _ := strconv.Atoi(l.number(i - 1))
+			return strconv.Itoa(n + 1)
+		}
+	case "padded":
+		last := start + l.count - 1
+		if last < start+i {
+			last = start + i
+		}
+		return fmt.Sprintf("%0*d", len(strconv.Itoa(last)), start+i)
+	}
+	return strconv.Itoa(start + i)
+}
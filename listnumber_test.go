@@ -0,0 +1,56 @@
+package mdtool
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestScanOrderedLists(t *testing.T) {
+	cases := []struct {
+		src  string
+		want [][]string
+	}{
+		{"1. a\n2. b\n", [][]string{{"1", "2"}}},
+		{"3. a\n3. b\n\n   more\n\n4. c\n", [][]string{{"3", "3", "4"}}},
+		{"1. a\n   1. x\n   2. y\n2. b\n", [][]string{{"1", "2"}, {"1", "2"}}},
+		{"- a\n  7. x\n  8. y\n- b\n\n1. c\n", [][]string{{"7", "8"}, {"1"}}},
+		{"1. a\n\ntext\n\n1. b\n", [][]string{{"1"}, {"1"}}},
+		{"1. a\n- b\n2. c\n", [][]string{{"1"}, {"2"}}},
+		{"> 5) a\n> 6) b\n", [][]string{{"5", "6"}}},
+		{"```\n1. code\n```\n\n    2. code\n\n1. a\n   ```\n   2. code\n   ```\n", [][]string{{"1"}}},
+	}
+	for idx, tt := range cases {
+		got := scanOrderedLists([]byte(tt.src))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Case %d) got %v, want %v", idx, got, tt.want)
+		}
+	}
+}
+
+func TestFmtListNumbering(t *testing.T) {
+	long := "1. a\n2. b\n3. c\n4. d\n5. e\n6. f\n7. g\n8. h\n9. i\n10. j\n"
+	cases := []struct {
+		src  string
+		mode string
+		want string
+	}{
+		{"3. a\n3. b\n3. c\n", "", "3. a\n4. b\n5. c"},
+		{"3. a\n3. b\n3. c\n", "sequential", "3. a\n4. b\n5. c"},
+		{"1. a\n2. b\n3. c\n", "one", "1. a\n1. b\n1. c"},
+		{"1. a\n1. b\n5. c\n", "preserve", "1. a\n1. b\n5. c"},
+		{long, "padded", "01. a\n02. b\n03. c\n04. d\n05. e\n06. f\n07. g\n08. h\n09. i\n10. j"},
+
+		// the text of long items lines up with the widest number
+		{"8. aaaa bbbb\n9. cccc dddd\n10. eeee ffff\n", "", "8. aaaa\n    bbbb\n9. cccc\n    dddd\n10. eeee\n    ffff"},
+	}
+	for idx, tt := range cases {
+		opt := &FmtOptions{ListNumbering: tt.mode, ListIndent: "    ", ListBulletSpace: " ", LineLength: 9}
+		for _, format := range []func([]byte, *FmtOptions) []byte{Fmt, Fmt2} {
+			got := strings.TrimSpace(string(format([]byte(tt.src), opt)))
+			if got != tt.want {
+				t.Errorf("Case %d)\n%q\n%q", idx, tt.want, got)
+			}
+		}
+	}
+}
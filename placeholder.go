@@ -0,0 +1,37 @@
+package mdtool
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+)
+
+// The formatters replace parts of the source with placeholder words
+// before parsing, and put them back in the output, see
+// extractFootnotes, extractLinkRefs, extractCodeInfo and markCodeLines.
+// Each placeholder has a nonce made for the run, so it can't be in the
+// input.  The rendered text also uses marker bytes, see escapeMarker
+// and hardBreakMarker, which a document could have, so one with any of
+// them is left as it is.
+
+var placeholderNonce = newNonce()
+
+func newNonce() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("%X", b)
+}
+
+// placeholder returns the word standing for the i'th extracted item of
+// a kind
+func placeholder(kind string, i int) string {
+	return fmt.Sprintf("MDTOOLS%s%s%dEND", placeholderNonce, kind, i)
+}
+
+// hasMarkers is true if src has any of the bytes used as markers in
+// rendered text
+func hasMarkers(src []byte) bool {
+	return bytes.IndexAny(src, string([]byte{hardBreakMarker, lineBreakMarker, escapeMarker})) != -1
+}
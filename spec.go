@@ -0,0 +1,103 @@
+package mdtool
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/russross/blackfriday"
+	bf "gopkg.in/russross/blackfriday.v2"
+)
+
+// SpecExample is an example from the CommonMark or GFM spec, as in the
+// JSON written by the spec's test script, e.g.
+//
+//	python3 test/spec_tests.py --dump-tests > spec.json
+type SpecExample struct {
+	Markdown string `json:"markdown"`
+	HTML     string `json:"html"`
+	Example  int    `json:"example"`
+	Section  string `json:"section"`
+}
+
+// LoadSpec reads the examples from a spec JSON file
+func LoadSpec(filename string) ([]SpecExample, error) {
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var examples []SpecExample
+	if err := json.Unmarshal(raw, &examples); err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	return examples, nil
+}
+
+// SpecSection is how the formatters did on the examples of one section
+// of the spec.  An example is lossy if the formatted Markdown renders to
+// different HTML than the example does.
+type SpecSection struct {
+	Name      string
+	Examples  int
+	FmtLossy  []int // example numbers
+	Fmt2Lossy []int
+}
+
+// CheckSpec formats each example with Fmt and Fmt2 and compares the
+// HTML before and after, tallied by section in the order of the spec.
+// The HTML is rendered by the blackfriday version each formatter
+// parses with, so only what formatting changes is counted, not where
+// blackfriday differs from the spec.
+func CheckSpec(examples []SpecExample, opt *FmtOptions) []SpecSection {
+	var sections []SpecSection
+	index := make(map[string]int)
+	for _, ex := range examples {
+		i, ok := index[ex.Section]
+		if !ok {
+			i = len(sections)
+			index[ex.Section] = i
+			sections = append(sections, SpecSection{Name: ex.Section})
+		}
+		s := &sections[i]
+		s.Examples++
+		src := []byte(ex.Markdown)
+		if !keepsHTML(src, Fmt, opt, fmtHTML) {
+			s.FmtLossy = append(s.FmtLossy, ex.Example)
+		}
+		if !keepsHTML(src, Fmt2, opt, fmt2HTML) {
+			s.Fmt2Lossy = append(s.Fmt2Lossy, ex.Example)
+		}
+	}
+	return sections
+}
+
+// keepsHTML is true if src renders the same after formatting.  A
+// formatter that panics doesn't.
+func keepsHTML(src []byte, format func([]byte, *FmtOptions) []byte, opt *FmtOptions, render func([]byte) string) (ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	return render(src) == render(format(src, opt))
+}
+
+// fmtHTML renders Markdown the way Fmt parses it
+func fmtHTML(src []byte) string {
+	return normalizeHTML(blackfriday.Markdown(src, blackfriday.HtmlRenderer(0, "", ""), fmtExtensions))
+}
+
+// fmt2HTML renders Markdown the way Fmt2 parses it
+func fmt2HTML(src []byte) string {
+	return normalizeHTML(bf.Run(src, bf.WithExtensions(bf.CommonExtensions)))
+}
+
+var htmlTag = regexp.MustCompile(`\s*(<[^>]*>)\s*`)
+
+// normalizeHTML collapses whitespace and removes it around tags, since
+// formatting may rewrap text
+func normalizeHTML(html []byte) string {
+	return htmlTag.ReplaceAllString(strings.Join(strings.Fields(string(html)), " "), "$1")
+}
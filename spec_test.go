@@ -0,0 +1,71 @@
+package mdtool
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var specFile = flag.String("spec", "", "CommonMark or GFM spec JSON for TestSpec")
+
+func TestCheckSpec(t *testing.T) {
+	root, err := ioutil.TempDir("", "mdtools")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	name := filepath.Join(root, "spec.json")
+	spec := `[
+  {"markdown": "# foo\n", "html": "<h1>foo</h1>\n", "example": 1, "start_line": 1, "end_line": 5, "section": "ATX headings"},
+  {"markdown": "*foo*\n", "html": "<p><em>foo</em></p>\n", "example": 2, "start_line": 6, "end_line": 10, "section": "Emphasis"},
+  {"markdown": "Term\n: def\n", "html": "<dl>\n<dt>Term</dt>\n<dd>def</dd>\n</dl>\n", "example": 3, "start_line": 11, "end_line": 16, "section": "ATX headings"}
+]`
+	if err := ioutil.WriteFile(name, []byte(spec), 0644); err != nil {
+		t.Fatal(err)
+	}
+	examples, err := LoadSpec(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := CheckSpec(examples, nil)
+	want := []SpecSection{
+		{Name: "ATX headings", Examples: 2, FmtLossy: []int{3}, Fmt2Lossy: []int{3}},
+		{Name: "Emphasis", Examples: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if _, err := LoadSpec(filepath.Join(root, "missing.json")); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+}
+
+// TestSpec reports how the formatters do on the spec examples, e.g.
+//
+//	go test -run TestSpec -spec spec.json -v
+func TestSpec(t *testing.T) {
+	if *specFile == "" {
+		t.Skip("no -spec file")
+	}
+	examples, err := LoadSpec(*specFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmtKept, fmt2Kept := 0, 0
+	for _, s := range CheckSpec(examples, nil) {
+		fmtKept += s.Examples - len(s.FmtLossy)
+		fmt2Kept += s.Examples - len(s.Fmt2Lossy)
+		t.Logf("%s: fmt %d/%d, fmt2 %d/%d", s.Name, s.Examples-len(s.FmtLossy), s.Examples, s.Examples-len(s.Fmt2Lossy), s.Examples)
+		if len(s.FmtLossy) != 0 {
+			t.Logf("  fmt lossy: %v", s.FmtLossy)
+		}
+		if len(s.Fmt2Lossy) != 0 {
+			t.Logf("  fmt2 lossy: %v", s.Fmt2Lossy)
+		}
+	}
+	t.Logf("total: fmt %d/%d, fmt2 %d/%d", fmtKept, len(examples), fmt2Kept, len(examples))
+}
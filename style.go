@@ -0,0 +1,212 @@
+package mdtool
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	bf "gopkg.in/russross/blackfriday.v2"
+)
+
+// builtinStyles are the presets for the fmt style setting and the
+// --style flag, as fmt settings.  More can be defined under styles in
+// a config file.
+//
+// Hugo renders with blackfriday (up to 0.60), which only sees a nested
+// list, or code in a list item, when it is indented by a multiple of 4
+// spaces.  fmt2 parses with it too, so all the styles use a 4 space
+// list indent.  They all put one space after a list marker, since vet
+// reports more as list-marker-spacing.
+var builtinStyles = map[string]map[string]interface{}{
+	"github": {
+		"list_bullet":       "-",
+		"list_bullet_space": " ",
+		"list_indent":       "    ",
+		"heading_style":     "atx",
+		"emphasis":          "_",
+		"strong":            "*",
+		"hr_char":           "-",
+		"hr_length":         3,
+		"table_compact":     false,
+		"wrap":              "fill",
+		"line_length":       -1,
+	},
+	"commonmark": {
+		"list_bullet":       "-",
+		"list_bullet_space": " ",
+		"list_indent":       "    ",
+		"heading_style":     "atx",
+		"emphasis":          "*",
+		"strong":            "*",
+		"hr_char":           "*",
+		"hr_length":         3,
+		"table_compact":     true,
+		"wrap":              "fill",
+		"line_length":       80,
+	},
+	"hugo": {
+		"list_bullet":       "-",
+		"list_bullet_space": " ",
+		"list_indent":       "    ",
+		"heading_style":     "atx",
+		"emphasis":          "_",
+		"strong":            "*",
+		"hr_char":           "-",
+		"hr_length":         3,
+		"table_compact":     false,
+		"wrap":              "sentence",
+		"line_length":       -1,
+	},
+	"google": {
+		"list_bullet":       "*",
+		"list_bullet_space": " ",
+		"list_indent":       "    ",
+		"list_numbering":    "one",
+		"heading_style":     "atx",
+		"emphasis":          "*",
+		"strong":            "*",
+		"hr_char":           "-",
+		"hr_length":         3,
+		"table_compact":     false,
+		"wrap":              "fill",
+		"line_length":       80,
+	},
+}
+
+// Style returns the fmt settings of a style preset, from the config or
+// built in.
+func (c *Config) Style(name string) (map[string]interface{}, error) {
+	styles, _ := c.values["styles"].(map[string]interface{})
+	if s, ok := styles[name].(map[string]interface{}); ok {
+		return s, nil
+	}
+	if s, ok := builtinStyles[name]; ok {
+		return s, nil
+	}
+	names := []string{}
+	for n := range builtinStyles {
+		names = append(names, n)
+	}
+	for n := range styles {
+		if builtinStyles[n] == nil {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("unknown style %q, expected one of %s", name, strings.Join(names, ", "))
+}
+
+// StyleOptions returns the formatting options with a style preset
+// applied over the config settings, as for the --style flag.
+func (c *Config) StyleOptions(name string) (FmtOptions, error) {
+	style, err := c.Style(name)
+	if err != nil {
+		return FmtOptions{}, err
+	}
+	m := make(map[string]interface{})
+	for k, v := range c.section("fmt") {
+		m[k] = v
+	}
+	for k, v := range style {
+		m[k] = v
+	}
+	m["style"] = name
+	return fmtOptions(m), nil
+}
+
+// applyStyle applies the style set by a config layer, if any.  The
+// other fmt settings of the layer are applied again after it, so they
+// win over the preset.
+func (c *Config) applyStyle(layer map[string]interface{}) error {
+	settings, _ := layer["fmt"].(map[string]interface{})
+	name, _ := settings["style"].(string)
+	if name == "" {
+		return nil
+	}
+	style, err := c.Style(name)
+	if err != nil {
+		return err
+	}
+	mergeConfig(c.section("fmt"), style)
+	mergeConfig(c.section("fmt"), settings)
+	return nil
+}
+
+// emphasisMarker returns the marker for emphasis, or strong emphasis if
+// n is 2.  An underscore only works at the edge of a word, so * is used
+// inside of one.
+func emphasisMarker(char string, n int, intraword bool) string {
+	if char != "_" || intraword {
+		char = "*"
+	}
+	return strings.Repeat(char, n)
+}
+
+// nestedEmphasisMarker switches a marker between * and _ if the text it
+// is around starts or ends with the same character, since for example
+// **a* b* would be read as strong emphasis, or has a marker of the same
+// kind, as in *a *b* c*.  Emphasis inside a word must use *.
+func nestedEmphasisMarker(marker string, text []byte, intraword bool) string {
+	c := marker[0]
+	if intraword || (len(text) == 0 || text[0] != c && text[len(text)-1] != c) && !hasMarkerRun(text, c, len(marker)) {
+		return marker
+	}
+	return strings.Repeat(otherEmphasisChar(marker), len(marker))
+}
+
+// hasMarkerRun is true if text has an unescaped run of exactly n of c
+func hasMarkerRun(text []byte, c byte, n int) bool {
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\\' {
+			i++
+			continue
+		}
+		j := i
+		for j < len(text) && text[j] == c {
+			j++
+		}
+		if j-i == n {
+			return true
+		}
+		if j > i {
+			i = j - 1
+		}
+	}
+	return false
+}
+
+func otherEmphasisChar(marker string) string {
+	if marker[0] == '_' {
+		return "*"
+	}
+	return "_"
+}
+
+// isIntraword is true if an emphasis node touches a letter or digit on
+// either side, e.g. the b in a*b*c
+func isIntraword(node *bf.Node) bool {
+	if prev := node.Prev; prev != nil && prev.Type == bf.Text {
+		if r, _ := utf8.DecodeLastRune(prev.Literal); isAlnum(r) {
+			return true
+		}
+	}
+	if next := node.Next; next != nil && next.Type == bf.Text {
+		if r, _ := utf8.DecodeRune(next.Literal); isAlnum(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// endsWord is true if the output so far ends with a letter or digit
+func endsWord(out *bytes.Buffer) bool {
+	r, _ := utf8.DecodeLastRune(out.Bytes())
+	return isAlnum(r)
+}
+
+func isAlnum(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,125 @@
+package mdtool
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigStyle(t *testing.T) {
+	root, err := ioutil.TempDir("", "mdtools")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	files := map[string]string{
+		".mdtools.yaml": `fmt:
+  style: google
+  line_length: 100
+styles:
+  team:
+    list_bullet: "+"
+    wrap: clause
+`,
+		"team/.mdtools.yaml": "fmt:\n  style: team\n",
+		"bad/.mdtools.yaml":  "fmt:\n  style: nope\n",
+		"x.md":               "",
+		"team/y.md":          "",
+		"bad/z.md":           "",
+	}
+	for name, content := range files {
+		name = filepath.Join(root, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(name), 0755)
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, err := LoadConfig(filepath.Join(root, "x.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opt := c.FmtOptions()
+	if opt.ListBulletChar != "*" || opt.ListIndent != "    " || opt.LineLength != 100 {
+		t.Errorf("x.md: expected google style with line length 100, got %+v", opt)
+	}
+	opt, err = c.StyleOptions("hugo")
+	if err != nil || opt.ListBulletChar != "-" || opt.WrapMode != "sentence" {
+		t.Errorf("x.md: expected hugo style, got %+v %v", opt, err)
+	}
+	if _, err := c.StyleOptions("nope"); err == nil {
+		t.Errorf("x.md: expected error for unknown style")
+	}
+
+	c, err = LoadConfig(filepath.Join(root, "team/y.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opt = c.FmtOptions()
+	if opt.ListBulletChar != "+" || opt.WrapMode != "clause" || opt.ListIndent != "    " {
+		t.Errorf("team/y.md: expected team style over google, got %+v", opt)
+	}
+
+	if _, err := LoadConfig(filepath.Join(root, "bad/z.md")); err == nil {
+		t.Errorf("bad/z.md: expected error for unknown style")
+	}
+}
+
+func TestFmtEmphasis(t *testing.T) {
+	cases := []struct {
+		src    string
+		emph   string
+		strong string
+		want   string
+	}{
+		{"*a* **b**", "", "", "*a* **b**"},
+		{"*a* **b**", "_", "_", "_a_ __b__"},
+		{"_a_ __b__", "*", "*", "*a* **b**"},
+		{"x *a* y **b**.", "_", "*", "x _a_ y **b**."},
+		{"**a *b* c**", "_", "_", "__a _b_ c__"},
+	}
+	for idx, tt := range cases {
+		opt := &FmtOptions{LineLength: 70, EmphasisChar: tt.emph, StrongChar: tt.strong}
+		for _, format := range []func([]byte, *FmtOptions) []byte{Fmt, Fmt2} {
+			got := strings.TrimSpace(string(format([]byte(tt.src), opt)))
+			if got != tt.want {
+				t.Errorf("Case %d)\n%q\n%q", idx, tt.want, got)
+			}
+		}
+	}
+
+	// _ doesn't work inside a word
+	opt := &FmtOptions{LineLength: 70, EmphasisChar: "_"}
+	if got := strings.TrimSpace(string(Fmt2([]byte("un*frigging*believable"), opt))); got != "un*frigging*believable" {
+		t.Errorf("intraword: got %q", got)
+	}
+}
+
+func TestBuiltinStylesNesting(t *testing.T) {
+	src := "- a\n    - b\n        - c\n"
+	for name := range builtinStyles {
+		opt, err := (&Config{values: defaultConfig()}).StyleOptions(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opt.ListBulletChar, opt.ListBulletSpace = "-", " "
+		if got := string(Fmt2([]byte(src), &opt)); got != src {
+			t.Errorf("%s: got %q, want %q", name, got, src)
+		}
+	}
+}
+
+func TestBuiltinStylesVet(t *testing.T) {
+	src := "- one\n- two\n\n1. one\n2. two\n"
+	for name := range builtinStyles {
+		opt, err := (&Config{values: defaultConfig()}).StyleOptions(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range Vet(Fmt2([]byte(src), &opt), nil) {
+			t.Errorf("%s: %s", name, f.Reason.Name())
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package mdtool
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	bf "gopkg.in/russross/blackfriday.v2"
+)
+
+// VerifyError describes where the structure of formatted markdown
+// differs from the original.
+type VerifyError struct {
+	// Path is the node types from the document down to the node
+	// that differs, e.g. "Document > List > Item > Paragraph"
+	Path string
+
+	// Orig and Formatted describe the node in each document.
+	// One is empty if the node is missing.
+	Orig      string
+	Formatted string
+}
+
+func (e *VerifyError) Error() string {
+	orig, formatted := e.Orig, e.Formatted
+	if orig == "" {
+		orig = "nothing"
+	}
+	if formatted == "" {
+		formatted = "nothing"
+	}
+	return fmt.Sprintf("%s: %s became %s", e.Path, orig, formatted)
+}
+
+// Verify parses the original and formatted markdown and compares the
+// trees, ignoring differences in whitespace outside of code.  It
+// returns a *VerifyError for the first node that differs, or nil if
+// formatting kept the meaning of the document.  Code blocks must be
+// the same, except for whitespace in a language with a built-in
+// formatter, see DefaultCodeFormatters.
+func Verify(orig, formatted []byte) error {
+	return VerifyCode(orig, formatted, DefaultCodeFormatters())
+}
+
+// VerifyCode is Verify, with formatters for the languages whose code
+// may have been reformatted
+func VerifyCode(orig, formatted []byte, formatters CodeFormatters) error {
+	a := verifyNodes(orig, formatters)
+	b := verifyNodes(formatted, formatters)
+	for i := 0; i < len(a) || i < len(b); i++ {
+		switch {
+		case i == len(a):
+			return &VerifyError{Path: b[i].path, Formatted: b[i].desc}
+		case i == len(b):
+			return &VerifyError{Path: a[i].path, Orig: a[i].desc}
+		case a[i] != b[i]:
+			orig, formatted := shortenDiff(a[i].desc, b[i].desc)
+			return &VerifyError{Path: a[i].path, Orig: orig, Formatted: formatted}
+		}
+	}
+	return nil
+}
+
+// shortenDiff cuts long descriptions, such as the text of a paragraph,
+// down to the part around where they differ.
+func shortenDiff(a, b string) (string, string) {
+	const context = 20
+	start := 0
+	for start < len(a) && start < len(b) && a[start] == b[start] {
+		start++
+	}
+	cut := func(s string) string {
+		if start > 2*context {
+			s = "..." + s[start-context:]
+		}
+		if len(s) > 4*context {
+			s = s[:4*context] + "..."
+		}
+		return s
+	}
+	return cut(a), cut(b)
+}
+
+// verifyNode is the normalized form of one node
+type verifyNode struct {
+	path string
+	desc string
+}
+
+// verifyNodes flattens the tree of a document into a list of normalized
+// nodes.  Text broken up by escapes or line breaks is joined back
+// together so only the text itself is compared.
+func verifyNodes(src []byte, formatters CodeFormatters) []verifyNode {
+	var nodes []verifyNode
+	var path []string
+	var text bytes.Buffer // text of adjacent Text nodes
+	flushText := func() {
+		if t := collapseSpace(text.String()); t != "" {
+			nodes = append(nodes, verifyNode{
+				path: strings.Join(path, " > "),
+				desc: fmt.Sprintf("Text %q", t),
+			})
+		}
+		text.Reset()
+	}
+	// front matter can be normalized, so only its presence matters
+	fm, src := SplitFrontMatter(src)
+	if fm != nil {
+		nodes = append(nodes, verifyNode{path: "Document > FrontMatter", desc: "FrontMatter"})
+	}
+	doc := bf.New(bf.WithExtensions(bf.CommonExtensions)).Parse(src)
+	doc.Walk(func(node *bf.Node, entering bool) bf.WalkStatus {
+		if node.Type == bf.Text {
+			if entering {
+				text.Write(node.Literal)
+			}
+			return bf.GoToNext
+		}
+		flushText()
+		if !entering {
+			path = path[:len(path)-1]
+			return bf.GoToNext
+		}
+		path = append(path, node.Type.String())
+		nodes = append(nodes, verifyNode{
+			path: strings.Join(path, " > "),
+			desc: describeNode(node, formatters),
+		})
+		if isLeaf(node) {
+			path = path[:len(path)-1]
+		}
+		return bf.GoToNext
+	})
+	flushText()
+	return nodes
+}
+
+// isLeaf is true for nodes that Walk only visits once
+func isLeaf(node *bf.Node) bool {
+	switch node.Type {
+	case bf.Text, bf.HTMLBlock, bf.CodeBlock, bf.Softbreak, bf.Hardbreak, bf.Code, bf.HTMLSpan, bf.HorizontalRule:
+		return true
+	}
+	return false
+}
+
+// describeNode returns the parts of a node that matter for its meaning
+func describeNode(node *bf.Node, formatters CodeFormatters) string {
+	s := node.Type.String()
+	switch node.Type {
+	case bf.Heading:
+		s += fmt.Sprintf(" level=%d", node.HeadingData.Level)
+	case bf.List:
+		if node.ListFlags&bf.ListTypeOrdered != 0 {
+			s += " ordered"
+		}
+		if node.ListFlags&bf.ListTypeDefinition != 0 {
+			s += " definition"
+		}
+		if !node.Tight {
+			s += " loose"
+		}
+	case bf.Link, bf.Image:
+		s += fmt.Sprintf(" %q", node.LinkData.Destination)
+		if len(node.LinkData.Title) != 0 {
+			s += fmt.Sprintf(" title=%q", node.LinkData.Title)
+		}
+	case bf.CodeBlock:
+		// code with a formatter may be reformatted, e.g. by gofmt, so
+		// its whitespace is ignored
+		info := bytes.TrimSpace(node.Info)
+		code := string(node.Literal)
+		if formatters[codeLang(string(info))] != nil {
+			code = strings.Join(strings.Fields(code), "")
+		}
+		s += fmt.Sprintf(" %q %q", info, code)
+	case bf.Code, bf.HTMLSpan, bf.HTMLBlock:
+		s += fmt.Sprintf(" %q", collapseSpace(string(node.Literal)))
+	case bf.TableCell:
+		if node.IsHeader {
+			s += " header"
+		}
+		if node.Align != 0 {
+			s += fmt.Sprintf(" align=%d", node.Align)
+		}
+	}
+	return s
+}
+
+// collapseSpace trims the text and turns each run of whitespace into a
+// single space.  Whitespace between CJK characters is removed, since a
+// line break there isn't a space.
+func collapseSpace(s string) string {
+	words := strings.Fields(s)
+	out := strings.Builder{}
+	for i, w := range words {
+		if i != 0 {
+			prev, _ := utf8.DecodeLastRuneInString(words[i-1])
+			next, _ := utf8.DecodeRuneInString(w)
+			if !isIdeographic(prev) || !isIdeographic(next) {
+				out.WriteByte(' ')
+			}
+		}
+		out.WriteString(w)
+	}
+	return out.String()
+}
@@ -0,0 +1,65 @@
+package mdtool
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	cases := []struct {
+		orig, formatted string
+		want            string // part of the error, "" for no error
+	}{
+		{"# h1", "#  h1\n", ""},
+		{"a long\nparagraph  of text", "a long paragraph of\ntext", ""},
+		{"a_b", "a\\_b", ""},
+		{"```go\nx:=1\n```", "```go\nx := 1\n```", ""},
+		{"# h1", "## h1", "Heading level=1 became Heading level=2"},
+		{"a *b* c", "a b c", "Document > Paragraph"},
+		{"[a](/b)", "[a](/c)", `Link "/b" became Link "/c"`},
+		{"- a\n- b", "- a\n\n- b", "List became List loose"},
+		{"a\n\nb", "a", "Paragraph became nothing"},
+		{"```python\nif x:\n    y\n```", "```python\nif x:\ny\n```", "CodeBlock"},
+		{"```yaml\na:\n  b: 1\n```", "```yaml\na:\n    b: 1\n```", "CodeBlock"},
+		{"```\nall:\n\tmake\n```", "```\nall:\n    make\n```", "CodeBlock"},
+		{"    indented\n      code", "```\nindented\n  code\n```", ""},
+		{"- a", "\\- a", "List became Paragraph"},
+		{"- a\n    - b", "- a\n- b", "Document > List > Item"},
+		{"`a  b`", "`a b`", ""},
+		{"`a b`", "`ab`", `Code "a b" became Code "ab"`},
+	}
+	for idx, tt := range cases {
+		err := Verify([]byte(tt.orig), []byte(tt.formatted))
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("Case %d) unexpected error: %s", idx, err)
+		case tt.want != "" && err == nil:
+			t.Errorf("Case %d) expected error %q", idx, tt.want)
+		case tt.want != "" && !strings.Contains(err.Error(), tt.want):
+			t.Errorf("Case %d) expected %q, got %q", idx, tt.want, err)
+		}
+	}
+}
+
+func TestVerifyFixtures(t *testing.T) {
+	files, err := filepath.Glob("fixtures/*.md")
+	if err != nil {
+		t.Fatalf("Unable to get testcase files: %s", err)
+	}
+	for _, filename := range files {
+		raw, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatalf("Unable to read %q: %s", filename, err)
+		}
+		for name, format := range map[string]func([]byte, *FmtOptions) []byte{"Fmt": Fmt, "Fmt2": Fmt2} {
+			if name == "Fmt" && fmtLossyFixtures[filename] {
+				continue
+			}
+			if err := Verify(raw, format(raw, nil)); err != nil {
+				t.Errorf("%s %s: %s", name, filename, err)
+			}
+		}
+	}
+}
@@ -2,6 +2,7 @@ package mdtool
 
 import (
 	"bytes"
+	"fmt"
 	"sort"
 )
 
@@ -25,8 +26,37 @@ const (
 	FaultLinkTextWhitespace = FaultType(4)
 	// FaultLinkURLWhitespace is a broken URL with URL having newlines
 	FaultLinkURLWhitespace = FaultType(5)
+	// FaultLinkSpaceBetweenTextAndLink is whitespace between ']' and '('
+	FaultLinkSpaceBetweenTextAndLink = FaultType(6)
 	// FaultCodeFenceTrailingWhitespace is WS after a ```"
 	FaultCodeFenceTrailingWhitespace = FaultType(7)
+	// FaultLinkTextURLSeparateLines is link text and URL on different lines
+	FaultLinkTextURLSeparateLines = FaultType(8)
+	// FaultLinkTitleMultiline is a link title with newlines
+	FaultLinkTitleMultiline = FaultType(9)
+	// FaultLinkTitleNewline is a link title or ')' on a new line
+	FaultLinkTitleNewline = FaultType(10)
+	// FaultNakedURLParens is a naked URL containing parentheses
+	FaultNakedURLParens = FaultType(11)
+	// FaultListIndentedCode is indented text in a list that isn't code
+	FaultListIndentedCode = FaultType(12)
+	// FaultListMarkerSpacing is more than one space after a list marker
+	FaultListMarkerSpacing = FaultType(13)
+	// FaultOrphanPage is a page not reachable from any entry point
+	FaultOrphanPage = FaultType(14)
+	// FaultDuplicateTitle is a page with the same title as another
+	FaultDuplicateTitle = FaultType(15)
+	// FaultRedirectPage is a page that only links elsewhere
+	FaultRedirectPage = FaultType(16)
+	// FaultRedirectCycle is a redirect page that eventually links to itself
+	FaultRedirectCycle = FaultType(17)
+
+	// FaultCodeFormat is a fenced code block its formatter failed on,
+	// usually a syntax error.  Only checked if VetOptions.CodeFormatters
+	// is set.
+	FaultCodeFormat = FaultType(18)
+	// FaultMixedLineEndings is a file with both \n and \r\n line endings
+	FaultMixedLineEndings = FaultType(19)
 )
 
 func (s FaultType) String() string {
@@ -41,23 +71,228 @@ func (s FaultType) String() string {
 		return "Link Text with Whitespace"
 	case FaultLinkURLWhitespace:
 		return "Link URL with Whitespace"
+	case FaultLinkSpaceBetweenTextAndLink:
+		return "Space between Link Text and URL"
 	case FaultCodeFenceTrailingWhitespace:
 		return "Whitespace after ``` tag"
+	case FaultLinkTextURLSeparateLines:
+		return "Link Text and URL on Separate Lines"
+	case FaultLinkTitleMultiline:
+		return "Link Title with Newlines"
+	case FaultLinkTitleNewline:
+		return "Link Title on Separate Line"
+	case FaultNakedURLParens:
+		return "Naked URL with Parentheses"
+	case FaultListIndentedCode:
+		return "Indented Text in List is not Code"
+	case FaultListMarkerSpacing:
+		return "Extra Space after List Marker"
+	case FaultOrphanPage:
+		return "Orphan Page"
+	case FaultDuplicateTitle:
+		return "Duplicate Title"
+	case FaultRedirectPage:
+		return "Redirect Page"
+	case FaultRedirectCycle:
+		return "Redirect Cycle"
+	case FaultCodeFormat:
+		return "Code Format Error"
+	case FaultMixedLineEndings:
+		return "Mixed Line Endings"
 	}
 	return "FAIL"
 }
 
+// Name returns a short identifier for the fault type, suitable for
+// use in configuration files and command line flags
+func (s FaultType) Name() string {
+	switch s {
+	case FaultRunawayCodeFence:
+		return "runaway-code-fence"
+	case FaultRunawayLinkText:
+		return "runaway-link-text"
+	case FaultRunawayLinkURL:
+		return "runaway-link-url"
+	case FaultLinkTextWhitespace:
+		return "link-text-whitespace"
+	case FaultLinkURLWhitespace:
+		return "link-url-whitespace"
+	case FaultLinkSpaceBetweenTextAndLink:
+		return "link-space-between-text-and-url"
+	case FaultCodeFenceTrailingWhitespace:
+		return "code-fence-trailing-whitespace"
+	case FaultLinkTextURLSeparateLines:
+		return "link-text-url-separate-lines"
+	case FaultLinkTitleMultiline:
+		return "link-title-multiline"
+	case FaultLinkTitleNewline:
+		return "link-title-newline"
+	case FaultNakedURLParens:
+		return "naked-url-parens"
+	case FaultListIndentedCode:
+		return "list-indented-code"
+	case FaultListMarkerSpacing:
+		return "list-marker-spacing"
+	case FaultOrphanPage:
+		return "orphan-page"
+	case FaultDuplicateTitle:
+		return "duplicate-title"
+	case FaultRedirectPage:
+		return "redirect-page"
+	case FaultRedirectCycle:
+		return "redirect-cycle"
+	case FaultCodeFormat:
+		return "code-format"
+	case FaultMixedLineEndings:
+		return "mixed-line-endings"
+	}
+	return ""
+}
+
+// Severity is the default severity of the fault type
+func (s FaultType) Severity() Severity {
+	switch s {
+	case FaultCodeFenceTrailingWhitespace,
+		FaultLinkSpaceBetweenTextAndLink,
+		FaultLinkTextURLSeparateLines,
+		FaultLinkTitleMultiline,
+		FaultLinkTitleNewline,
+		FaultNakedURLParens,
+		FaultListIndentedCode,
+		FaultListMarkerSpacing,
+		FaultOrphanPage,
+		FaultDuplicateTitle,
+		FaultCodeFormat,
+		FaultMixedLineEndings:
+		return SeverityWarning
+	case FaultRedirectPage:
+		return SeverityInfo
+	}
+	return SeverityError
+}
+
+// faultTypes is every known fault type, in order
+var faultTypes = []FaultType{
+	FaultRunawayCodeFence,
+	FaultRunawayLinkText,
+	FaultRunawayLinkURL,
+	FaultLinkTextWhitespace,
+	FaultLinkURLWhitespace,
+	FaultLinkSpaceBetweenTextAndLink,
+	FaultCodeFenceTrailingWhitespace,
+	FaultLinkTextURLSeparateLines,
+	FaultLinkTitleMultiline,
+	FaultLinkTitleNewline,
+	FaultNakedURLParens,
+	FaultListIndentedCode,
+	FaultListMarkerSpacing,
+	FaultOrphanPage,
+	FaultDuplicateTitle,
+	FaultRedirectPage,
+	FaultRedirectCycle,
+	FaultCodeFormat,
+	FaultMixedLineEndings,
+}
+
+// ParseFaultType converts a name as returned by FaultType.Name back
+// into a FaultType.  FaultZero is returned if the name is unknown.
+func ParseFaultType(name string) FaultType {
+	for _, ft := range faultTypes {
+		if ft.Name() == name {
+			return ft
+		}
+	}
+	return FaultZero
+}
+
+// Severity describes how serious a fault is
+type Severity int
+
+const (
+	// SeverityError is a fault that breaks the rendered output
+	SeverityError = Severity(0)
+	// SeverityWarning is a fault that likely is a mistake
+	SeverityWarning = Severity(1)
+	// SeverityInfo is a fault that is a matter of style
+	SeverityInfo = Severity(2)
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	case SeverityInfo:
+		return "info"
+	}
+	return "unknown"
+}
+
+// ParseSeverity converts "error", "warning" or "info" into a Severity
+func ParseSeverity(name string) (Severity, error) {
+	switch name {
+	case "error":
+		return SeverityError, nil
+	case "warning":
+		return SeverityWarning, nil
+	case "info":
+		return SeverityInfo, nil
+	}
+	return SeverityError, fmt.Errorf("unknown severity %q", name)
+}
+
 // Fault defined the type and location of markdown problem
 type Fault struct {
-	Offset int
-	Reason FaultType
-	Row    int
-	Column int
-	Line   string
+	Offset   int
+	Reason   FaultType
+	Severity Severity
+	Row      int
+	Column   int
+	Line     string
 }
 
-// GetLine converts an offset into line with row, col info
+// VetOptions specifies options for vetting.
+type VetOptions struct {
+	// Severity overrides the default severity of a fault type
+	Severity map[FaultType]Severity
+
+	// Disable turns off reporting of a fault type
+	Disable map[FaultType]bool
+
+	// EntryPoints are the file name patterns of pages that don't need
+	// to be linked to, e.g. "README.md".  Used by VetDocs.
+	EntryPoints []string
+
+	// CodeFormatters, if set, are run on fenced code blocks and any
+	// failure is reported as FaultCodeFormat.
+	CodeFormatters CodeFormatters
+}
+
+// severity returns the severity of a fault type, taking any
+// override into account
+func (opt *VetOptions) severity(ft FaultType) Severity {
+	if opt != nil {
+		if s, ok := opt.Severity[ft]; ok {
+			return s
+		}
+	}
+	return ft.Severity()
+}
+
+// enabled returns true if the fault type should be reported
+func (opt *VetOptions) enabled(ft FaultType) bool {
+	return opt == nil || !opt.Disable[ft]
+}
+
+// GetLine converts an offset into line with row, col info.  The line
+// doesn't include a \r before the \n, and a byte order mark isn't
+// counted as part of the first line.
 func GetLine(raw []byte, offset int) (row, col int, line string) {
+	if bytes.HasPrefix(raw, byteOrderMark) && offset >= len(byteOrderMark) {
+		raw = raw[len(byteOrderMark):]
+		offset -= len(byteOrderMark)
+	}
 	for {
 		row++
 		idx := bytes.IndexByte(raw, '\n')
@@ -65,7 +300,7 @@ func GetLine(raw []byte, offset int) (row, col int, line string) {
 			break
 		}
 		if idx >= offset {
-			return row, offset, string(raw[:idx])
+			return row, offset, string(bytes.TrimSuffix(raw[:idx], []byte{'\r'}))
 		}
 		idx++
 		offset -= idx
@@ -75,12 +310,17 @@ func GetLine(raw []byte, offset int) (row, col int, line string) {
 }
 
 func verifyURL(raw []byte, faults []Fault) []Fault {
+	code := fencedCode(raw)
 	for idx := 0; idx < len(raw); idx++ {
 		i := bytes.IndexByte(raw[idx:], '[')
 		if i == -1 {
 			break
 		}
 		start := idx + i
+		if end := inRange(code, start); end != -1 {
+			idx = end
+			continue
+		}
 		i += idx + 1
 		j := bytes.IndexByte(raw[i:], ']')
 		if j == -1 {
@@ -94,9 +334,13 @@ func verifyURL(raw []byte, faults []Fault) []Fault {
 		desc := raw[i : i+j]
 		i += j + 1
 
-		// skip space and tabs
+		// skip space and tabs, and at most one newline
 		spaceCount := 0
-		for i < len(raw) && (raw[i] == ' ' || raw[i] == '\t') {
+		newline := false
+		for i < len(raw) && (raw[i] == ' ' || raw[i] == '\t' || (raw[i] == '\n' && !newline)) {
+			if raw[i] == '\n' {
+				newline = true
+			}
 			spaceCount++
 			i++
 		}
@@ -109,16 +353,19 @@ func verifyURL(raw []byte, faults []Fault) []Fault {
 			continue
 		}
 
-		/*
-			// if we had spaces between ']' and '(' then
-			// something is wrong.
-			if spaceCount > 0 {
-				faults = append(faults, Fault{
-					Offset: start,
-					Reason: FaultLinkSpaceBetweenTextAndLink,
-				})
-			}
-		*/
+		// if we had spaces between ']' and '(' then
+		// something is wrong.
+		if newline {
+			faults = append(faults, Fault{
+				Offset: start,
+				Reason: FaultLinkTextURLSeparateLines,
+			})
+		} else if spaceCount > 0 {
+			faults = append(faults, Fault{
+				Offset: start,
+				Reason: FaultLinkSpaceBetweenTextAndLink,
+			})
+		}
 		i++
 		j = bytes.IndexByte(raw[i:], ')')
 		if j == -1 {
@@ -138,10 +385,10 @@ func verifyURL(raw []byte, faults []Fault) []Fault {
 				Reason: FaultLinkTextWhitespace,
 			})
 		}
-		if bytes.IndexByte(aurl, '\n') != -1 {
+		if reason := verifyURLTitle(aurl); reason != FaultZero {
 			faults = append(faults, Fault{
 				Offset: start,
-				Reason: FaultLinkURLWhitespace,
+				Reason: reason,
 			})
 		}
 
@@ -153,6 +400,32 @@ func verifyURL(raw []byte, faults []Fault) []Fault {
 	return faults
 }
 
+// verifyURLTitle checks the part of a link between '(' and ')',
+// the destination and optional title, for misplaced newlines.
+func verifyURLTitle(aurl []byte) FaultType {
+	if bytes.IndexByte(aurl, '\n') == -1 {
+		return FaultZero
+	}
+	rest := bytes.TrimLeft(aurl, " \t\n")
+	dest := rest
+	if k := bytes.IndexAny(rest, " \t\n"); k != -1 {
+		dest = rest[:k]
+	}
+	rest = rest[len(dest):]
+	title := bytes.TrimSpace(rest)
+	switch {
+	case len(title) == 0:
+		// just a ')' on the next line
+		return FaultLinkTitleNewline
+	case title[0] != '"' && title[0] != '\'' && title[0] != '(':
+		// not a title, so the URL itself is broken
+		return FaultLinkURLWhitespace
+	case bytes.IndexByte(title, '\n') != -1:
+		return FaultLinkTitleMultiline
+	}
+	return FaultLinkTitleNewline
+}
+
 // runawayCodeFence looks for un-ended code fences
 //
 // returns -1 is code blocks seem ok
@@ -205,16 +478,43 @@ func runawayCodeFence(raw []byte, faults []Fault) []Fault {
 type VetFunc func([]byte, []Fault) []Fault
 
 // Vet is the main function to find structural problems with Markdown
-func Vet(raw []byte) []Fault {
+// If opt is nil the defaults are used.
+func Vet(raw []byte, opt *VetOptions) []Fault {
 	faults := []Fault{}
 	vetfuncs := []VetFunc{
 		verifyURL,
 		runawayCodeFence,
+		nakedURLParens,
+		listPitfalls,
 	}
 
+	// the rules only handle \n line endings
+	_, text := splitEncoding(raw)
 	for _, fn := range vetfuncs {
-		faults = fn(raw, faults)
+		faults = fn(text, faults)
+	}
+	if opt != nil && opt.CodeFormatters != nil {
+		faults = codeFormatFaults(text, faults, opt.CodeFormatters)
+	}
+	offsets := newOffsetMap(raw)
+	for i := range faults {
+		faults[i].Offset = offsets.raw(faults[i].Offset)
+	}
+	faults = mixedLineEndings(raw, faults)
+	return finishFaults(raw, faults, opt)
+}
+
+// finishFaults removes disabled faults, sorts the rest by location
+// and fills in line and severity information.
+func finishFaults(raw []byte, faults []Fault, opt *VetOptions) []Fault {
+	// remove any disabled faults
+	enabled := faults[:0]
+	for _, f := range faults {
+		if opt.enabled(f.Reason) {
+			enabled = append(enabled, f)
+		}
 	}
+	faults = enabled
 
 	if len(faults) == 0 {
 		return nil
@@ -230,6 +530,7 @@ func Vet(raw []byte) []Fault {
 		faults[i].Row = row
 		faults[i].Column = col
 		faults[i].Line = line
+		faults[i].Severity = opt.severity(faults[i].Reason)
 	}
 
 	return faults
@@ -0,0 +1,239 @@
+package mdtool
+
+import (
+	"bytes"
+	"path"
+	"strings"
+
+	bf "gopkg.in/russross/blackfriday.v2"
+)
+
+// Doc is a single markdown file in a set of documents
+type Doc struct {
+	// Name is the slash separated path of the file
+	Name string
+	Raw  []byte
+}
+
+// docInfo is what VetDocs needs to know about each document
+type docInfo struct {
+	title       string
+	titleOffset int
+	links       []string // other documents in the set linked to
+	redirect    bool
+}
+
+// VetDocs finds problems that need the whole document set, such as
+// pages no other page links to, or two pages with the same title.
+// The result is keyed by Doc.Name.  If opt is nil the defaults are used.
+func VetDocs(docs []Doc, opt *VetOptions) map[string][]Fault {
+	entries := []string{"README.md"}
+	if opt != nil && len(opt.EntryPoints) != 0 {
+		entries = opt.EntryPoints
+	}
+
+	names := make(map[string]bool, len(docs))
+	for _, d := range docs {
+		names[path.Clean(d.Name)] = true
+	}
+
+	info := make(map[string]*docInfo, len(docs))
+	for _, d := range docs {
+		name := path.Clean(d.Name)
+		info[name] = parseDocInfo(name, d.Raw, names)
+	}
+
+	faults := make(map[string][]Fault)
+
+	// orphans: walk the links from each entry point
+	seen := make(map[string]bool)
+	var queue []string
+	for _, d := range docs {
+		name := path.Clean(d.Name)
+		if isEntryPoint(name, entries) {
+			seen[name] = true
+			queue = append(queue, name)
+		}
+	}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		for _, link := range info[name].links {
+			if !seen[link] {
+				seen[link] = true
+				queue = append(queue, link)
+			}
+		}
+	}
+	for _, d := range docs {
+		name := path.Clean(d.Name)
+		if !seen[name] {
+			faults[d.Name] = append(faults[d.Name], Fault{
+				Reason: FaultOrphanPage,
+			})
+		}
+	}
+
+	// duplicate titles
+	titles := make(map[string][]Doc)
+	for _, d := range docs {
+		if t := info[path.Clean(d.Name)].title; t != "" {
+			titles[t] = append(titles[t], d)
+		}
+	}
+	for _, d := range docs {
+		di := info[path.Clean(d.Name)]
+		if di.title != "" && len(titles[di.title]) > 1 {
+			faults[d.Name] = append(faults[d.Name], Fault{
+				Offset: di.titleOffset,
+				Reason: FaultDuplicateTitle,
+			})
+		}
+	}
+
+	// redirects, and redirects that loop back on themselves
+	for _, d := range docs {
+		name := path.Clean(d.Name)
+		if !info[name].redirect {
+			continue
+		}
+		reason := FaultRedirectPage
+		visited := map[string]bool{name: true}
+		for next := name; info[next] != nil && info[next].redirect && len(info[next].links) != 0; {
+			next = info[next].links[0]
+			if next == name {
+				reason = FaultRedirectCycle
+				break
+			}
+			if visited[next] {
+				break
+			}
+			visited[next] = true
+		}
+		faults[d.Name] = append(faults[d.Name], Fault{
+			Reason: reason,
+		})
+	}
+
+	for _, d := range docs {
+		if f := finishFaults(d.Raw, faults[d.Name], opt); f != nil {
+			faults[d.Name] = f
+		} else {
+			delete(faults, d.Name)
+		}
+	}
+	return faults
+}
+
+func isEntryPoint(name string, entries []string) bool {
+	for _, pattern := range entries {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, path.Base(name)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveLink converts a link destination into the name of a document
+// in the set, or "" if it doesn't point to one.
+func resolveLink(from string, dest string, names map[string]bool) string {
+	if strings.Contains(dest, ":") || strings.HasPrefix(dest, "/") {
+		// absolute URL, or scheme like mailto:
+		return ""
+	}
+	if i := strings.IndexAny(dest, "#?"); i != -1 {
+		dest = dest[:i]
+	}
+	if dest == "" {
+		return ""
+	}
+	target := path.Join(path.Dir(from), dest)
+	for _, name := range []string{
+		target,
+		target + ".md",
+		path.Join(target, "README.md"),
+		path.Join(target, "index.md"),
+	} {
+		if names[name] {
+			return name
+		}
+	}
+	return ""
+}
+
+func parseDocInfo(name string, raw []byte, names map[string]bool) *docInfo {
+	info := &docInfo{}
+	body := raw
+	if title, offset, rest := frontMatterTitle(raw); rest != nil {
+		info.title = title
+		info.titleOffset = offset
+		body = rest
+	}
+
+	md := bf.New(bf.WithExtensions(bf.CommonExtensions))
+	doc := md.Parse(body)
+
+	// a redirect has at least one link, and no text outside of links
+	// and headings
+	otherText := false
+	linkCount := 0
+	doc.Walk(func(node *bf.Node, entering bool) bf.WalkStatus {
+		if !entering {
+			return bf.GoToNext
+		}
+		switch node.Type {
+		case bf.Heading:
+			if info.title == "" && node.HeadingData.Level == 1 {
+				info.title = strings.TrimSpace(string(nodeText(node)))
+				if i := bytes.Index(raw, []byte(info.title)); i != -1 {
+					info.titleOffset = i
+				}
+			}
+			return bf.SkipChildren
+		case bf.Link:
+			linkCount++
+			if target := resolveLink(name, string(node.LinkData.Destination), names); target != "" {
+				info.links = append(info.links, target)
+			}
+			return bf.SkipChildren
+		case bf.Text, bf.Code, bf.CodeBlock, bf.HTMLBlock, bf.Image:
+			if len(bytes.TrimSpace(node.Literal)) != 0 || node.Type == bf.Image {
+				otherText = true
+			}
+		}
+		return bf.GoToNext
+	})
+	info.redirect = !otherText && linkCount != 0
+	return info
+}
+
+// nodeText returns the literal text of a node and its children
+func nodeText(node *bf.Node) []byte {
+	buf := bytes.Buffer{}
+	node.Walk(func(n *bf.Node, entering bool) bf.WalkStatus {
+		if entering {
+			buf.Write(n.Literal)
+		}
+		return bf.GoToNext
+	})
+	return buf.Bytes()
+}
+
+// frontMatterTitle looks for a title in front matter.  rest is the
+// document after the front matter, or nil if there isn't any.
+func frontMatterTitle(raw []byte) (title string, offset int, rest []byte) {
+	fm, rest := SplitFrontMatter(raw)
+	if fm == nil {
+		return "", 0, nil
+	}
+	if values, err := fm.Values(); err == nil {
+		title, _ = values["title"].(string)
+	}
+	if i := bytes.Index(fm.Raw, []byte(title)); title != "" && i != -1 {
+		offset = i
+	}
+	return title, offset, rest
+}
@@ -0,0 +1,255 @@
+package mdtool
+
+import (
+	"bytes"
+)
+
+// The "pitfall" rules flag markdown that blackfriday accepts but
+// other parsers (GitHub, CommonMark) render differently, or that
+// renders differently than the author likely intended.
+//
+// See mdtest.md for examples of each.
+
+// codeBlock is a fenced code block, with the code between the fences
+// less the block quote markers and indent of the opening fence
+type codeBlock struct {
+	start, end int // offsets of the block, fences included
+	info       string
+	code       []byte
+}
+
+// fencedCodeBlocks finds the ``` and ~~~ fenced code blocks, also those
+// indented or in block quotes, as in list items.  A fence indented 4
+// spaces past the text it would be in is part of indented code or a
+// paragraph.  A block that isn't closed runs to the end of the input.
+func fencedCodeBlocks(raw []byte) []codeBlock {
+	var blocks []codeBlock
+	var block *codeBlock
+	fence := ""
+	depth, indent := 0, 0
+	content := 0 // column of the text of the list item lines may be in
+	blank := false
+	for offset := 0; offset < len(raw); {
+		line := nextLine(raw, offset)
+		offset += len(line)
+		if block != nil {
+			// strip the opening fence's quote markers and indent
+			text := line
+			for i := 0; i < depth; i++ {
+				trimmed := bytes.TrimLeft(text, " ")
+				if len(trimmed) == 0 || trimmed[0] != '>' {
+					break
+				}
+				text = bytes.TrimPrefix(trimmed[1:], []byte(" "))
+			}
+			for i := 0; i < indent && len(text) != 0 && text[0] == ' '; i++ {
+				text = text[1:]
+			}
+			if m := codeFence.FindSubmatch(bytes.TrimLeft(text, " \t")); m != nil &&
+				len(m[2]) == 0 && m[1][0] == fence[0] && len(m[1]) >= len(fence) {
+				block.end = offset
+				blocks = append(blocks, *block)
+				block = nil
+				continue
+			}
+			block.code = append(block.code, text...)
+			continue
+		}
+		prefix := quotePrefix.Find(line)
+		text := line[len(prefix):]
+		trimmed := bytes.TrimLeft(text, " \t")
+		if len(bytes.TrimSpace(trimmed)) == 0 {
+			blank = true
+			continue
+		}
+		col := textWidth(string(text[:len(text)-len(trimmed)]), 0, 4)
+		if col == 0 && blank {
+			// the end of any list
+			content = 0
+		}
+		blank = false
+		if n := listMarker(trimmed); n > 0 && col < content+4 {
+			content = col + n
+			continue
+		}
+		if col >= content+4 {
+			continue
+		}
+		m := codeFence.FindSubmatch(trimmed)
+		if m == nil || m[1][0] == '`' && bytes.IndexByte(m[2], '`') != -1 {
+			continue
+		}
+		block = &codeBlock{start: offset - len(line), info: string(m[2])}
+		fence = string(m[1])
+		depth = bytes.Count(prefix, []byte{'>'})
+		indent = len(text) - len(trimmed)
+	}
+	if block != nil {
+		block.end = len(raw)
+		blocks = append(blocks, *block)
+	}
+	return blocks
+}
+
+// fencedCode returns the [start,end) offsets of each fenced code block
+func fencedCode(raw []byte) [][2]int {
+	var ranges [][2]int
+	for _, b := range fencedCodeBlocks(raw) {
+		ranges = append(ranges, [2]int{b.start, b.end})
+	}
+	return ranges
+}
+
+// inRange returns the end of the range containing offset, or -1
+func inRange(ranges [][2]int, offset int) int {
+	for _, r := range ranges {
+		if offset >= r[0] && offset < r[1] {
+			return r[1]
+		}
+	}
+	return -1
+}
+
+// nakedURLParens finds bare URLs that contain '(' or ')'.
+//
+// Blackfriday ends an autolink at the first ')', so
+// https://example.com/?(foo) becomes a link to https://example.com/?(foo
+// Wrapping in <> or [text](url) avoids this.  A ) ending a URL in
+// parentheses, as in (see https://example.com/), is fine.
+func nakedURLParens(raw []byte, faults []Fault) []Fault {
+	code := fencedCode(raw)
+	for idx := 0; idx < len(raw); {
+		i := bytes.Index(raw[idx:], []byte("://"))
+		if i == -1 {
+			break
+		}
+		colon := idx + i
+		start := colon
+		for start > 0 && isLetter(raw[start-1]) {
+			start--
+		}
+		end := colon + 3
+		for end < len(raw) && !isSpace(raw[end]) && raw[end] != '>' {
+			end++
+		}
+		idx = end
+		if start == colon || inRange(code, start) != -1 {
+			continue
+		}
+		if start > 0 {
+			switch raw[start-1] {
+			case '<', '(', '"', '\'', '`':
+				// inside an autolink, link, title or code
+				continue
+			}
+		}
+		lineStart := bytes.LastIndexByte(raw[:start], '\n') + 1
+		if line := nextLine(raw, lineStart); linkRefDef.Match(line[len(quotePrefix.Find(line)):]) {
+			// a definition's URL runs to the space
+			continue
+		}
+		// a ) with no ( in the URL closes the text's own parenthesis
+		url := bytes.TrimRight(raw[start:end], ".,;:!?")
+		for bytes.HasSuffix(url, []byte(")")) && bytes.Count(url, []byte("(")) < bytes.Count(url, []byte(")")) {
+			url = bytes.TrimRight(url[:len(url)-1], ".,;:!?")
+		}
+		if bytes.IndexAny(url, "()") != -1 {
+			faults = append(faults, Fault{
+				Offset: start,
+				Reason: FaultNakedURLParens,
+			})
+		}
+	}
+	return faults
+}
+
+// listPitfalls looks at each line for list problems:
+//
+// "3.  text" has extra spaces after the marker, which changes how much
+// following lines need to be indented.
+//
+// Text indented 4 spaces past the text of a list item after a blank
+// line is a code block in CommonMark, but blackfriday takes 4 spaces
+// off the item's lines and reads it as a paragraph unless it is
+// indented 8 spaces past the marker.
+func listPitfalls(raw []byte, faults []Fault) []Fault {
+	code := fencedCode(raw)
+	inList := false
+	blank := false
+	var items [][2]int // columns of the open items' markers and text
+	for offset := 0; offset < len(raw); {
+		line := raw[offset:]
+		if i := bytes.IndexByte(line, '\n'); i != -1 {
+			line = line[:i]
+		}
+		start := offset
+		offset += len(line) + 1
+		if end := inRange(code, start); end != -1 {
+			offset = end
+			inList = false
+			blank = false
+			continue
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			blank = true
+			continue
+		}
+		if n := listMarker(line); n > 0 {
+			inList = true
+			items = append(items[:0], [2]int{0, n})
+			if n < len(line) && (line[n] == ' ' || line[n] == '\t') && len(bytes.TrimSpace(line[n:])) > 0 {
+				faults = append(faults, Fault{
+					Offset: start,
+					Reason: FaultListMarkerSpacing,
+				})
+			}
+		} else if line[0] != ' ' && line[0] != '\t' {
+			inList = false
+		} else if inList {
+			text := bytes.TrimLeft(line, " ")
+			col := len(line) - len(text)
+			n := listMarker(text)
+			for len(items) > 1 && (n > 0 && col <= items[len(items)-1][0] || n == 0 && col < items[len(items)-1][1]) {
+				items = items[:len(items)-1]
+			}
+			item := items[len(items)-1]
+			switch {
+			case n > 0:
+				if col > item[0] {
+					items = append(items, [2]int{col, col + n})
+				}
+			case blank && col >= item[1]+4 && col < item[0]+8 && text[0] != '>':
+				faults = append(faults, Fault{
+					Offset: start,
+					Reason: FaultListIndentedCode,
+				})
+			}
+		}
+		blank = false
+	}
+	return faults
+}
+
+// listMarker returns the length of a list marker at the start of a
+// line, e.g. "- " or "12. ", or 0 if there isn't one.
+func listMarker(line []byte) int {
+	if len(line) >= 2 && (line[0] == '-' || line[0] == '*' || line[0] == '+') && line[1] == ' ' {
+		return 2
+	}
+	i := 0
+	for i < len(line) && line[i] >= '0' && line[i] <= '9' {
+		i++
+	}
+	if i > 0 && i+1 < len(line) && line[i] == '.' && line[i+1] == ' ' {
+		return i + 2
+	}
+	return 0
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
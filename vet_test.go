@@ -1,6 +1,9 @@
 package mdtool
 
 import (
+	"io/ioutil"
+	"regexp"
+	"strings"
 	"testing"
 )
 
@@ -95,14 +98,163 @@ var cases = []testcase{
 		input:  "``` go\ncode\n```\nsomething\n",
 		faults: []Fault{},
 	},
+	// trailing whitespace after code fence with \r\n
+	{
+		input: "```\r\ncode\r\n``` \r\nsomething\r\n",
+		faults: []Fault{
+			{
+				Reason: FaultCodeFenceTrailingWhitespace,
+			},
+		},
+	},
+	// mixed line endings
+	{
+		input: "one\r\ntwo\nthree\r\n",
+		faults: []Fault{
+			{
+				Reason: FaultMixedLineEndings,
+			},
+		},
+	},
 }
 
 func TestVet(t *testing.T) {
 	for i, tt := range cases {
-		faults := Vet([]byte(tt.input))
+		faults := Vet([]byte(tt.input), nil)
 		if len(faults) != len(tt.faults) {
 			t.Errorf("%d: %q want %d faults got %d",
 				i, tt.input, len(tt.faults), len(faults))
 		}
 	}
 }
+
+func TestVetSeverity(t *testing.T) {
+	raw := []byte("```\ncode\n``` \nsomething\n")
+	faults := Vet(raw, nil)
+	if len(faults) != 1 || faults[0].Severity != SeverityWarning {
+		t.Fatalf("want one warning, got %+v", faults)
+	}
+	opt := &VetOptions{
+		Severity: map[FaultType]Severity{
+			FaultCodeFenceTrailingWhitespace: SeverityInfo,
+		},
+	}
+	faults = Vet(raw, opt)
+	if len(faults) != 1 || faults[0].Severity != SeverityInfo {
+		t.Fatalf("want one info, got %+v", faults)
+	}
+}
+
+func TestVetLineEndings(t *testing.T) {
+	cases := []struct {
+		input    string
+		reason   FaultType
+		row, col int
+		line     string
+	}{
+		{"a\n\n[text](http://golang.org/", FaultRunawayLinkURL, 3, 0, "[text](http://golang.org/"},
+		{"a\r\n\r\n[text](http://golang.org/", FaultRunawayLinkURL, 3, 0, "[text](http://golang.org/"},
+		{"\xef\xbb\xbfa [text](http://golang.org/", FaultRunawayLinkURL, 1, 2, "a [text](http://golang.org/"},
+		{"one\r\ntwo\nthree\r\n", FaultMixedLineEndings, 2, 3, "two"},
+		{"one\ntwo\r\nthree\n", FaultMixedLineEndings, 2, 3, "two"},
+	}
+	for i, tt := range cases {
+		faults := Vet([]byte(tt.input), nil)
+		if len(faults) != 1 {
+			t.Errorf("%d: %q want 1 fault got %+v", i, tt.input, faults)
+			continue
+		}
+		f := faults[0]
+		if f.Reason != tt.reason || f.Row != tt.row || f.Column != tt.col || f.Line != tt.line {
+			t.Errorf("%d: %q want %s at %d:%d %q, got %s at %d:%d %q", i, tt.input,
+				tt.reason, tt.row, tt.col, tt.line, f.Reason, f.Row, f.Column, f.Line)
+		}
+	}
+}
+
+// TestVetPitfalls runs each section of mdtest.md through Vet.
+//
+// Each section has a comment listing the faults it should produce:
+//
+//	<!-- vet: rule-name rule-name -->
+func TestVetPitfalls(t *testing.T) {
+	raw, err := ioutil.ReadFile("mdtest.md")
+	if err != nil {
+		t.Fatalf("Unable to read mdtest.md: %s", err)
+	}
+	sections := regexp.MustCompile(`(?m)^#+ `).Split(string(raw), -1)
+	marker := regexp.MustCompile(`<!-- vet:(.*)-->`)
+	for _, section := range sections[1:] {
+		title := section[:strings.IndexByte(section, '\n')]
+		m := marker.FindStringSubmatch(section)
+		if m == nil {
+			t.Errorf("%q: missing <!-- vet: --> comment", title)
+			continue
+		}
+		want := strings.Fields(m[1])
+		got := []string{}
+		for _, f := range Vet([]byte(section), nil) {
+			got = append(got, f.Reason.Name())
+		}
+		if strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Errorf("%q: want %v got %v", title, want, got)
+		}
+	}
+}
+
+func TestVetListIndentedCode(t *testing.T) {
+	cases := []struct {
+		src  string
+		want bool
+	}{
+		{"* item\n\n     text\n", false},
+		{"* item\n\n      code\n", true},
+		{"* item\n\n       code\n", true},
+		{"* item\n\n        code\n", false},
+		{"1. item\n\n      text\n", false},
+		{"1. item\n\n       code\n", true},
+		{"1. item\n\n        code\n", false},
+		{"1. item\n       text\n", false},
+	}
+	for idx, tt := range cases {
+		got := false
+		for _, f := range Vet([]byte(tt.src), nil) {
+			got = got || f.Reason == FaultListIndentedCode
+		}
+		if got != tt.want {
+			t.Errorf("Case %d) %q: expected %s %v, got %v", idx, tt.src, FaultListIndentedCode.Name(), tt.want, got)
+		}
+	}
+}
+
+func TestVetDocs(t *testing.T) {
+	docs := []Doc{
+		{Name: "README.md", Raw: []byte("# Home\n\nSee [a](a.md) and [r](sub/r.md).\n")},
+		{Name: "a.md", Raw: []byte("# A\n\ntext\n")},
+		{Name: "b.md", Raw: []byte("---\ntitle: A\n---\n\nhello\n")},
+		{Name: "sub/r.md", Raw: []byte("[go](../c.md)\n")},
+		{Name: "c.md", Raw: []byte("[back](sub/r.md#top)\n")},
+	}
+	want := map[string][]FaultType{
+		"a.md":     {FaultDuplicateTitle},
+		"b.md":     {FaultOrphanPage, FaultDuplicateTitle},
+		"sub/r.md": {FaultRedirectCycle},
+		"c.md":     {FaultRedirectCycle},
+	}
+	got := VetDocs(docs, nil)
+	if len(got) != len(want) {
+		t.Errorf("want faults in %d files, got %d: %v", len(want), len(got), got)
+	}
+	for name, reasons := range want {
+		faults := got[name]
+		if len(faults) != len(reasons) {
+			t.Errorf("%s: want %v got %+v", name, reasons, faults)
+			continue
+		}
+		for i, f := range faults {
+			if f.Reason != reasons[i] {
+				t.Errorf("%s: want %v got %+v", name, reasons, faults)
+			}
+		}
+	}
+}
@@ -2,6 +2,10 @@ package mdtool
 
 import (
 	"bytes"
+	"strings"
+	"unicode"
+
+	"github.com/mattn/go-runewidth"
 )
 
 func writeIndent(src []byte, indent string) []byte {
@@ -19,44 +23,403 @@ func writeIndent(src []byte, indent string) []byte {
 	return buf.Bytes()
 }
 
+// WrapOptions are the settings for wrapping a paragraph
+type WrapOptions struct {
+	// Length is the line length, or 1 or less for no limit
+	Length int
+
+	// Mode is "fill", "sentence" or "clause", see FmtOptions.WrapMode
+	Mode string
+
+	// TabWidth is the width of a tab in the prefix or indent.
+	// The default is 4.
+	TabWidth int
+}
+
 // WordWrap formats text word wrap, including initial prefix and ongoing
 // indentation
 func WordWrap(src []byte, length int, prefix string, indent string) []byte {
+	return Wrap(src, prefix, indent, WrapOptions{Length: length})
+}
+
+// Wrap wraps text by display width, so wide characters such as CJK
+// count as two columns.  Lines break at spaces, or between CJK
+// characters where Unicode line breaking (UAX #14) allows it.
+func Wrap(src []byte, prefix string, indent string, opt WrapOptions) []byte {
+	switch opt.Mode {
+	case "sentence":
+		return semanticWrap(src, prefix, indent, opt, false)
+	case "clause":
+		return semanticWrap(src, prefix, indent, opt, true)
+	}
+	return fillWrap(splitWords(src), prefix, indent, opt)
+}
+
+// wrapToken is a word, or the part of one that a line can break before
+type wrapToken struct {
+	text []byte
+	glue bool // no space before it
+}
+
+// splitWords splits text into words, and CJK words into the parts a
+// line can break between.  Code spans and link destinations are kept
+// whole, and a word that would change the meaning of a line if it
+// started one, such as "1." or "#", is joined to the word before it.
+func splitWords(src []byte) []wrapToken {
+	var tokens []wrapToken
+	for _, word := range markdownWords(src) {
+		if len(tokens) != 0 && unsafeLineStart(word) {
+			prev := &tokens[len(tokens)-1]
+			prev.text = append(append(append([]byte{}, prev.text...), ' '), word...)
+			continue
+		}
+		if bytes.ContainsAny(word, "` ") {
+			tokens = append(tokens, wrapToken{text: word})
+			continue
+		}
+		start := 0
+		var prev rune
+		for i, r := range string(word) {
+			if i != 0 && cjkBreak(prev, r) {
+				tokens = append(tokens, wrapToken{text: word[start:i], glue: start != 0})
+				start = i
+			}
+			prev = r
+		}
+		tokens = append(tokens, wrapToken{text: word[start:], glue: start != 0})
+	}
+	return tokens
+}
+
+// markdownWords splits text at whitespace, except inside code spans
+// and link destinations, e.g. `a b` or [text](url "a title")
+func markdownWords(src []byte) [][]byte {
+	var words [][]byte
+	word := []byte{}
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case isSpace(c):
+			if len(word) != 0 {
+				words = append(words, word)
+				word = []byte{}
+			}
+			continue
+		case c == '\\' && i+1 < len(src) && !isSpace(src[i+1]):
+			word = append(word, c, src[i+1])
+			i++
+			continue
+		case c == '`':
+			if end := codeSpanEnd(src, i); end != -1 {
+				word = append(word, collapseNewlines(src[i:end])...)
+				i = end - 1
+				continue
+			}
+		case c == ']' && i+1 < len(src) && src[i+1] == '(':
+			if end := linkDestinationEnd(src, i+1); end != -1 {
+				word = append(word, collapseNewlines(src[i:end])...)
+				i = end - 1
+				continue
+			}
+		}
+		word = append(word, c)
+	}
+	if len(word) != 0 {
+		words = append(words, word)
+	}
+	return words
+}
+
+// codeSpanEnd returns the end of the code span starting at a run of
+// backticks, or -1 if it isn't closed.
+func codeSpanEnd(src []byte, start int) int {
+	n := 0
+	for start+n < len(src) && src[start+n] == '`' {
+		n++
+	}
+	for i := start + n; i < len(src); {
+		if src[i] != '`' {
+			i++
+			continue
+		}
+		run := 0
+		for i+run < len(src) && src[i+run] == '`' {
+			run++
+		}
+		if run == n {
+			return i + run
+		}
+		i += run
+	}
+	return -1
+}
+
+// codeSpans returns the code spans in text, skipping backslash escapes
+// as markdownWords does.  A code span doesn't go past a blank line.
+func codeSpans(text []byte) [][2]int {
+	var spans [][2]int
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '`':
+			end := codeSpanEnd(text, i)
+			if end != -1 && !hasBlankLine(text[i:end]) {
+				spans = append(spans, [2]int{i, end})
+				i = end - 1
+				continue
+			}
+			for i+1 < len(text) && text[i+1] == '`' {
+				i++
+			}
+		}
+	}
+	return spans
+}
+
+// hasBlankLine is true if text has a line of only whitespace between
+// two others
+func hasBlankLine(text []byte) bool {
+	lines := bytes.Split(text, []byte{'\n'})
+	for i := 1; i < len(lines)-1; i++ {
+		if len(bytes.TrimSpace(lines[i])) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// linkDestinationEnd returns the end of (url "title") starting at the
+// open paren, or -1 if it isn't closed.
+func linkDestinationEnd(src []byte, start int) int {
+	depth := 0
+	var quote byte
+	for i := start; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' && depth == 1:
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
+func collapseNewlines(text []byte) []byte {
+	return bytes.Replace(bytes.Replace(text, []byte{'\r'}, nil, -1), []byte{'\n'}, []byte{' '}, -1)
+}
+
+// unsafeLineStart returns true if a line starting with the word would
+// be read as something other than paragraph text, such as a list item,
+// heading, block quote, setext underline, or code fence.
+func unsafeLineStart(word []byte) bool {
+	switch word[0] {
+	case '#', '>', '<', '|':
+		return true
+	case '-', '+', '*', '=', '_':
+		// bullets, and lines of only - or = are setext underlines or
+		// thematic breaks
+		if len(bytes.Trim(word, string(word[:1]))) == 0 {
+			return true
+		}
+	case '`', '~':
+		return bytes.HasPrefix(word, []byte("```")) || bytes.HasPrefix(word, []byte("~~~"))
+	}
+	// ordered list markers, 1. or 1)
+	i := 0
+	for i < len(word) && i < 10 && word[i] >= '0' && word[i] <= '9' {
+		i++
+	}
+	return i != 0 && i < 10 && i == len(word)-1 && (word[i] == '.' || word[i] == ')')
+}
+
+// fillWrap fills each line with as many tokens as fit
+func fillWrap(tokens []wrapToken, prefix string, indent string, opt WrapOptions) []byte {
 	out := bytes.Buffer{}
-	// TODO: do something about counting tab size
-	prefixLen := len(prefix)
-	indentLen := len(indent)
-	out.WriteString(prefix)
+	length := opt.Length
 	if length <= 1 {
 		length = 1 << 23
 	}
-	col := prefixLen
-	words := bytes.Fields(src)
-	for i, word := range words {
-		// if word is so big, just add it an call it a day
-		if len(word) >= length-indentLen {
-			if i != 0 {
-				out.WriteByte(' ')
-				col++
-			}
-			out.Write(word)
-			col += len(word)
-			continue
+	tabWidth := opt.TabWidth
+	if tabWidth <= 0 {
+		tabWidth = 4
+	}
+	indentWidth := textWidth(indent, 0, tabWidth)
+	out.WriteString(prefix)
+	col := textWidth(prefix, 0, tabWidth)
+	for i, tok := range tokens {
+		width := runewidth.StringWidth(string(tok.text))
+		space := 1
+		if i == 0 || tok.glue {
+			space = 0
 		}
-		if len(word)+col+1 < length {
-			if i != 0 {
+		// the first word always goes after the prefix, and a word
+		// too big for any line is just added
+		if i == 0 || width >= length-indentWidth || col+space+width < length {
+			if space != 0 {
 				out.WriteByte(' ')
-				col++
 			}
-			out.Write(word)
-			col += len(word)
+			out.Write(tok.text)
+			col += space + width
 			continue
 		}
 		// word overflows
 		out.WriteByte('\n')
 		out.WriteString(indent)
-		out.Write(word)
-		col = indentLen + len(word)
+		out.Write(tok.text)
+		col = indentWidth + width
 	}
 	return out.Bytes()
 }
+
+// textWidth returns the column after writing s starting at col, with
+// tabs going to the next tab stop.
+func textWidth(s string, col int, tabWidth int) int {
+	for _, r := range s {
+		if r == '\t' {
+			col += tabWidth - col%tabWidth
+			continue
+		}
+		col += runewidth.RuneWidth(r)
+	}
+	return col
+}
+
+const (
+	// closing punctuation (UAX #14 classes CL, CP, EX, IS), and small
+	// kana and iteration marks (NS), which can't start a line
+	cjkNoBreakBefore = "、。，．・：；？！）」』】〕〉》〗〙〛｝］｠｡｣､ゝゞーヽヾぁぃぅぇぉっゃゅょゎゕゖァィゥェォッャュョヮヵヶ々〻"
+
+	// opening punctuation (OP), which can't end a line
+	cjkNoBreakAfter = "（「『【〔〈《〖〘〚｛［｟｢"
+)
+
+// cjkBreak returns true if a line can break between two characters of
+// a word.  Following UAX #14, a break is allowed next to an ideographic
+// character, but not before closing punctuation or small kana, nor
+// after opening punctuation.
+func cjkBreak(prev, r rune) bool {
+	if !isIdeographic(prev) && !isIdeographic(r) {
+		return false
+	}
+	return !strings.ContainsRune(cjkNoBreakBefore, r) && !strings.ContainsRune(cjkNoBreakAfter, prev)
+}
+
+// isIdeographic is true for Han, kana, and CJK punctuation and
+// fullwidth forms.
+func isIdeographic(r rune) bool {
+	switch {
+	case unicode.Is(unicode.Han, r),
+		unicode.Is(unicode.Hiragana, r),
+		unicode.Is(unicode.Katakana, r),
+		r >= 0x3000 && r <= 0x303f, // CJK symbols and punctuation
+		r >= 0xff01 && r <= 0xff60: // fullwidth forms
+		return true
+	}
+	return false
+}
+
+// abbreviations are words ending in a period that don't end a sentence
+var abbreviations = map[string]bool{
+	"e.g.": true, "i.e.": true, "cf.": true, "vs.": true, "viz.": true,
+	"dr.": true, "mr.": true, "mrs.": true, "ms.": true, "prof.": true,
+	"st.": true, "jr.": true, "sr.": true, "no.": true, "vol.": true,
+	"fig.": true, "eq.": true, "approx.": true, "inc.": true, "ltd.": true,
+	"co.": true, "corp.": true, "dept.": true, "est.": true, "ca.": true,
+}
+
+// SemanticWrap puts each sentence on its own line, and if clauses is
+// true each clause ending in ; or : as well.  A sentence that is
+// longer than length is word wrapped.  Code spans and link destinations
+// aren't split, and a line doesn't start with a word that would change
+// its meaning, see unsafeLineStart.
+func SemanticWrap(src []byte, length int, prefix string, indent string, clauses bool) []byte {
+	return semanticWrap(src, prefix, indent, WrapOptions{Length: length}, clauses)
+}
+
+func semanticWrap(src []byte, prefix string, indent string, opt WrapOptions, clauses bool) []byte {
+	out := bytes.Buffer{}
+	words := markdownWords(src)
+	if len(words) == 0 {
+		out.WriteString(prefix)
+		return out.Bytes()
+	}
+	start := 0
+	for i, word := range words {
+		next := []byte(nil)
+		if i+1 < len(words) {
+			next = words[i+1]
+		}
+		if next != nil && (unsafeLineStart(next) || !endsSentence(word, next) && !(clauses && endsClause(word))) {
+			continue
+		}
+		if start != 0 {
+			out.WriteByte('\n')
+			prefix = indent
+		}
+		sentence := bytes.Join(words[start:i+1], []byte{' '})
+		out.Write(fillWrap(splitWords(sentence), prefix, indent, opt))
+		start = i + 1
+	}
+	return out.Bytes()
+}
+
+// endsSentence returns true if word ends a sentence, given the word
+// after it.
+func endsSentence(word, next []byte) bool {
+	// closing quotes, parens and emphasis can follow the period
+	w := bytes.TrimRight(word, `"')]*_`)
+	if len(w) < 2 {
+		return false
+	}
+	switch w[len(w)-1] {
+	case '.':
+		if abbreviations[string(bytes.ToLower(w))] {
+			return false
+		}
+		// initials such as "J." and acronyms such as "U.S."
+		if isUpper(w[len(w)-2]) && (len(w) == 2 || w[len(w)-3] == '.') {
+			return false
+		}
+	case '!', '?':
+		break
+	default:
+		return false
+	}
+	// a number such as "2." is more likely a step than a sentence
+	if unsafeLineStart(w) {
+		return false
+	}
+	// the next sentence starts with a capital, digit or quote
+	n := bytes.TrimLeft(next, `"'([*_`)
+	if len(n) == 0 {
+		return false
+	}
+	c := n[0]
+	return isUpper(c) || (c >= '0' && c <= '9') || c >= 0x80
+}
+
+// endsClause returns true if word ends with ; or :
+func endsClause(word []byte) bool {
+	w := bytes.TrimRight(word, `"')]*_`)
+	if len(w) < 2 {
+		return false
+	}
+	return w[len(w)-1] == ';' || w[len(w)-1] == ':'
+}
+
+func isUpper(c byte) bool {
+	return c >= 'A' && c <= 'Z'
+}
@@ -0,0 +1,116 @@
+package mdtool
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSemanticWrap(t *testing.T) {
+	cases := []struct {
+		clauses bool
+		length  int
+		src     string
+		want    string
+	}{
+		{false, 70, "One. Two! Three? four", "One.\nTwo!\nThree? four"},
+		{false, 70, "See e.g. the docs. Ask Dr. Who about it.", "See e.g. the docs.\nAsk Dr. Who about it."},
+		{false, 70, "Go 1.10 is out. Use v2.0.1 now.", "Go 1.10 is out.\nUse v2.0.1 now."},
+		{false, 70, "Made in the U.S. By J. Smith.", "Made in the U.S. By J. Smith."},
+		{false, 70, `He said "stop." Then left.`, "He said \"stop.\"\nThen left."},
+		{false, 70, "first; second: third", "first; second: third"},
+		{true, 70, "first; second: third", "first;\nsecond:\nthird"},
+		{false, 20, "A sentence that is much too long to fit. Short.",
+			"A sentence that is\nmuch too long to\nfit.\nShort."},
+		{false, 70, "", ""},
+		// code spans, link titles and destinations aren't split
+		{false, 70, "Use `a. B` here. Next.", "Use `a. B` here.\nNext."},
+		{false, 70, `See [a](http://x.com "A title. Next one") now. Next.`, "See [a](http://x.com \"A title. Next one\") now.\nNext."},
+		{false, 70, "See [x](http://x.com/a. B) now.", "See [x](http://x.com/a. B) now."},
+		// a line can't start with a list marker
+		{false, 70, "Do step one. 2. Then two.", "Do step one. 2. Then two."},
+		{true, 70, "Steps: 2. Then two.", "Steps: 2. Then two."},
+	}
+	for idx, tt := range cases {
+		got := string(SemanticWrap([]byte(tt.src), tt.length, "", "", tt.clauses))
+		if got != tt.want {
+			t.Errorf("Case %d)\n%q\n%q", idx, tt.want, got)
+		}
+	}
+}
+
+func TestFmtWrapMode(t *testing.T) {
+	src := "First sentence here. Second one; with a clause.\n\n- Item one. Item two.\n"
+	want := "First sentence here.\nSecond one; with a clause.\n\n- Item one.\n  Item two."
+	for _, format := range []func([]byte, *FmtOptions) []byte{Fmt, Fmt2} {
+		opt := &FmtOptions{LineLength: 70, ListBulletChar: "-", ListIndent: "  ", ListBulletSpace: " ", WrapMode: "sentence"}
+		got := strings.TrimSpace(string(format([]byte(src), opt)))
+		if got != want {
+			t.Errorf("expected:\n%s\ngot:\n%s", want, got)
+		}
+	}
+}
+
+func TestWrapWidth(t *testing.T) {
+	cases := []struct {
+		src    string
+		length int
+		prefix string
+		indent string
+		tab    int
+		want   string
+	}{
+		// accented letters are one column even though two bytes
+		{"café café café", 15, "", "", 0, "café café café"},
+		// wide characters are two columns
+		{"😀😀 😀😀 😀😀", 12, "", "", 0, "😀😀 😀😀\n😀😀"},
+		// CJK text breaks between characters
+		{"日本語のテキストです", 11, "", "", 0, "日本語のテ\nキストです"},
+		// but not before closing punctuation, or after opening
+		{"これは「テスト」です。", 9, "", "", 0, "これは\n「テス\nト」で\nす。"},
+		// mixed text keeps the spaces between words
+		{"Go 言語", 70, "", "", 0, "Go 言語"},
+		// tabs go to the next tab stop
+		{"aaa bbb ccc", 14, "\t", "\t", 0, "\taaa bbb\n\tccc"},
+		{"aaa bbb ccc", 14, "\t", "\t", 2, "\taaa bbb ccc"},
+	}
+	for idx, tt := range cases {
+		got := string(Wrap([]byte(tt.src), tt.prefix, tt.indent, WrapOptions{Length: tt.length, TabWidth: tt.tab}))
+		if got != tt.want {
+			t.Errorf("Case %d)\n%q\n%q", idx, tt.want, got)
+		}
+	}
+}
+
+func TestWrapMarkdownSafe(t *testing.T) {
+	paragraphs := []string{
+		"costs 5 - 3 = 2 dollars + tax and we need 1. more 2) things",
+		"see issue # 42 and > quoted and * star and <b>bold</b> text",
+		"a line of === and --- and *** and ___ is not a break here",
+		"use `a long code span with spaces` and ``two ` ticks`` inline",
+		"a [link with long text](https://example.com/a/b/c \"and a title\") here",
+		"fences ``` and ~~~ in the middle | of a | paragraph",
+		"日本語の文章 - と 1. リスト",
+		"Use `a. B` here; then `c: d` there. Done.",
+		`See [a](http://x.com "A title. Next one") now: Next. [x](http://x.com/a. B) too.`,
+		"Do step one. 2. Then # 3. And > 4: - 5.",
+	}
+	prefixes := [][2]string{{"", ""}, {"- ", "  "}, {"1. ", "   "}, {"> ", "> "}}
+	for _, mode := range []string{"fill", "sentence", "clause"} {
+		for _, p := range paragraphs {
+			for _, pi := range prefixes {
+				orig := []byte(pi[0] + p)
+				for length := 3; length <= 40; length++ {
+					got := Wrap([]byte(p), pi[0], pi[1], WrapOptions{Length: length, Mode: mode})
+					if err := Verify(orig, got); err != nil {
+						t.Errorf("%s length %d: %s\n%s", mode, length, err, got)
+					}
+					for _, line := range strings.Split(string(got), "\n") {
+						if strings.Count(line, "`")%2 != 0 && !strings.Contains(line, "``") {
+							t.Errorf("%s length %d: code span split:\n%s", mode, length, got)
+						}
+					}
+				}
+			}
+		}
+	}
+}